@@ -1,9 +1,17 @@
 package syslogp
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"net"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 type FrameScanner struct {
@@ -11,21 +19,455 @@ type FrameScanner struct {
 	err          error
 	buf          []byte
 	frame        []byte
+	trailer      []byte
 	start        int
 	end          int
 	offset       int
 	shift        int
+	scan         int
+	size         int
 	maxFrameSize int
+	framing      Framing
+	skip         bool
+	length       int
+	truncated    bool
+	grow         bool
+	resync       bool
+	resyncing    bool
+	inDigits     bool
+	skipping     int
+	onResync     func(skipped int)
+	rem          int
+	reader       frameReader
+	skipExceeded bool
+	onExceeded   func(size int)
+	ctx          context.Context
+	idleTimeout  time.Duration
+	frameTimeout time.Duration
+	began        time.Time
+	pool         *BufferPool
+	scratch      []byte
+	lent         []byte
+	borrowed     bool
+	returned     int
+	failed       bool
+	stats        frameStats
+	hook         FrameHook
+	drain        *drain
 }
 
 func (f *FrameScanner) Next() bool {
+	if f.next() {
+		n := len(f.frame)
+
+		if f.stats.frames.Add(1); f.truncated {
+			if f.stats.truncated.Add(1); f.length > n {
+				f.stats.dropped.Add(int64(f.length - n))
+			}
+		}
+		if int64(max(n, f.length)) > f.stats.maxFrame.Load() {
+			f.stats.maxFrame.Store(int64(max(n, f.length)))
+		}
+		if f.hook != nil {
+			f.hook.FrameRead(n, f.length)
+		}
+		return true
+	}
+	if f.pool != nil {
+		f.lend(false)
+	}
+	f.fail()
+	return false
+}
+
+// fail counts the error that ended the stream, once.
+func (f *FrameScanner) fail() {
+	if f.err == nil || f.err == io.EOF || f.failed {
+		return
+	}
+	switch f.failed = true; f.err {
+	case ErrIdleTimeout, ErrFrameTimeout:
+		f.stats.timeouts.Add(1)
+	default:
+		f.stats.errors.Add(1)
+	}
+	if f.hook != nil {
+		f.hook.FrameError(f.err)
+	}
+}
+
+// exceeded reports a frame of size bytes skipped as larger than maxFrameSize.
+func (f *FrameScanner) exceeded(size int) {
+	f.stats.exceeded.Add(1)
+
+	if f.hook != nil {
+		f.hook.FrameSkipped(size, ErrFrameExceeded)
+	}
+	if f.onExceeded != nil {
+		f.onExceeded(size)
+	}
+}
+
+// resynced reports the bytes discarded to resynchronise.
+func (f *FrameScanner) resynced() {
+	f.stats.resyncs.Add(1)
+	f.stats.skipped.Add(int64(f.skipping))
+
+	if f.hook != nil {
+		f.hook.FrameSkipped(f.skipping, ErrFrame)
+	}
+	if f.onResync != nil {
+		f.onResync(f.skipping)
+	}
+	f.skipping = 0
+}
+
+func (f *FrameScanner) next() bool {
 	var (
 		c         byte
 		frameSize int
 		n, m      int
+		digits    int
+		detected  = f.trailer == nil || f.skip
+	)
+	if f.began = (time.Time{}); !f.discard() {
+		return false
+	}
+_scan:
+	for {
+		if f.end > f.start && !detected {
+			switch c = f.buf[f.start]; c {
+			case '\n', '\r', 0:
+				f.start++
+				continue
+			}
+			if counted, more := msgLenPrefix(f.buf[f.start:f.end]); !more || f.err != nil || f.end-f.start == len(f.buf) {
+				if detected, f.framing = true, NonTransparent; counted {
+					f.framing = OctetCounting
+				}
+			}
+		}
+		if f.end > f.start && detected {
+			if f.framing == NonTransparent {
+				if i := trailerIndex(f.buf, f.trailer, f.start, f.end, &f.scan); i >= 0 {
+					f.frame = f.buf[f.start:i]
+					f.start = i + len(f.trailer)
+					n, f.size = f.size+len(f.frame), 0
+
+					if f.returned > 0 {
+						if f.stats.dropped.Add(int64(n - f.returned)); int64(n) > f.stats.maxFrame.Load() {
+							f.stats.maxFrame.Store(int64(n))
+						}
+						f.returned = 0
+					}
+					if 0 < f.maxFrameSize && f.maxFrameSize < n {
+						if !f.skipExceeded {
+							f.err = ErrFrameExceeded
+							return false
+						}
+						f.exceeded(n)
+					} else if !f.skip && len(f.frame) > 0 {
+						f.length, f.truncated = n, false
+						return true
+					}
+					f.skip, detected = false, false
+					continue
+				}
+				if 0 < f.maxFrameSize && f.maxFrameSize < f.size+f.scan {
+					if !f.skipExceeded {
+						f.err = ErrFrameExceeded
+						return false
+					}
+					f.skip = true
+					f.start += f.scan
+					f.size += f.scan
+					f.scan = 0
+				}
+			} else {
+				if f.resyncing && f.sync() {
+					f.resyncing = false
+					f.resynced()
+				}
+				if frameSize == 0 && !f.resyncing {
+					for ; f.start < f.end; f.start++ {
+						c = f.buf[f.start]
+
+						if m > 0 && c == ' ' {
+							if f.start++; f.skipExceeded && 0 < f.maxFrameSize && f.maxFrameSize < m {
+								f.rem = m
+								f.exceeded(m)
+
+								if !f.discard() {
+									return false
+								}
+								m, digits, detected = 0, 0, f.trailer == nil
+								continue _scan
+							}
+							m, frameSize, f.length = 0, m, m
+							break
+						}
+						if (m == 0 && c == '0') || '0' > c || c > '9' || digits == maxFrameDigits {
+							if !f.resync {
+								if f.err = ErrFrame; '0' <= c && c <= '9' && m > 0 {
+									f.err = ErrFrameLength
+								}
+								return false
+							}
+							f.resyncing = true
+							f.skipping += digits
+							m, digits = 0, 0
+							continue _scan
+						}
+						m *= 10
+						m += int(c - '0')
+						digits++
+
+						if 0 < f.maxFrameSize && f.maxFrameSize < m && !f.skipExceeded {
+							f.err = ErrFrameExceeded
+							return false
+						}
+					}
+					if f.pool != nil && frameSize > f.end-f.start && !f.lend(true) {
+						f.interrupt()
+						return false
+					}
+					if frameSize > len(f.buf) {
+						if f.grow && f.maxFrameSize > 0 {
+							f.resize(max(frameSize, min(2*len(f.buf), f.maxFrameSize)))
+						} else {
+							f.offset = frameSize - len(f.buf)
+							frameSize = len(f.buf)
+						}
+					}
+				}
+				n = f.end - f.start
+
+				if frameSize > n && f.err != nil {
+					frameSize = n
+				}
+				if 0 < frameSize && frameSize <= n {
+					f.truncated = frameSize < f.length
+					frameSize += f.start
+					f.frame = f.buf[f.start:frameSize]
+					f.start = frameSize
+					return true
+				}
+			}
+		}
+		if f.err != nil {
+			if detected && f.framing == NonTransparent && f.end > f.start && !f.skip {
+				f.frame = f.buf[f.start:f.end]
+				f.start = f.end
+				f.length, f.truncated = f.size+len(f.frame), false
+				return true
+			}
+			if f.resyncing {
+				f.resyncing, f.inDigits = false, false
+				f.skipping += f.end - f.start
+				f.resynced()
+			}
+			f.start = 0
+			f.end = 0
+			f.offset = 0
+			f.scan = 0
+			f.size = 0
+			f.skip = false
+			return false
+		}
+		partial := f.end > f.start || m > 0 || frameSize > 0 || f.size > 0 || f.skip || f.resyncing
+
+		if f.pool != nil && !f.lend(partial) {
+			f.interrupt()
+			return false
+		}
+		if detected && f.framing == NonTransparent && f.start == 0 && f.end == len(f.buf) {
+			if limit := f.maxFrameSize + len(f.trailer); f.grow && !f.skip && len(f.buf) < limit {
+				f.resize(min(2*len(f.buf), limit))
+				continue
+			}
+			n = f.end - len(f.trailer) + 1
+			f.frame = f.buf[:n]
+			f.start, f.size, f.scan = n, f.size+n, 0
+
+			if !f.skip {
+				f.skip, f.returned = true, n
+				f.length, f.truncated = -1, true
+				return true
+			}
+			continue
+		}
+		if f.start > 0 && (f.end == len(f.buf) || f.start >= f.shift) {
+			copy(f.buf, f.buf[f.start:f.end])
+			f.end -= f.start
+			f.start = 0
+		}
+		n, f.err = f.read(f.buf[f.end:], partial)
+
+		if f.end += n; interrupted(f.err) {
+			f.interrupt()
+			return false
+		}
+	}
+}
+
+// msgLenPrefix reports whether p begins with MSG-LEN SP, as an octet-counted
+// frame does, or, if p ends before telling, that more bytes are needed.
+func msgLenPrefix(p []byte) (counted, more bool) {
+	for i, c := range p {
+		switch {
+		case c == ' ':
+			return i > 0, false
+		case c < '0' || c > '9' || (i == 0 && c == '0') || i == maxFrameDigits:
+			return false, false
+		}
+	}
+	return false, true
+}
+
+// interrupt drops the partially read frame.
+func (f *FrameScanner) interrupt() {
+	f.start = 0
+	f.end = 0
+	f.scan = 0
+	f.size = 0
+	f.skip = false
+	f.resyncing = false
+}
+
+// NextContext is like Next, but gives up waiting for the frame when ctx is
+// done and fails with ctx.Err(). A blocked read is interrupted by setting
+// a read deadline in the past, which needs an io.Reader with SetReadDeadline,
+// such as net.Conn, otherwise ctx is checked only before every read.
+func (f *FrameScanner) NextContext(ctx context.Context) bool {
+	if err := ctx.Err(); err != nil {
+		f.err = err
+		return false
+	}
+	if ctx.Done() == nil {
+		return f.Next()
+	}
+	f.ctx = ctx
+	defer func() { f.ctx = nil }()
+
+	if d, ok := f.r.(readDeadliner); ok {
+		done := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			d.SetReadDeadline(aLongTimeAgo)
+			close(done)
+		})
+		defer func() {
+			if !stop() {
+				<-done
+			}
+		}()
+	}
+	return f.Next()
+}
+
+// NextReader advances to the next octet-counted frame and returns a reader
+// of it, limited to its MSG-LEN, which is returned too. The frame is read
+// straight from the underlying io.Reader, past the buffered data, so that it
+// is never held in memory. The rest of the frame that has not been read is
+// discarded by the next call of Next or NextReader, which invalidates
+// the reader. At the end of the stream NextReader returns io.EOF.
+func (f *FrameScanner) NextReader() (io.Reader, int, error) {
+	r, n, err := f.nextReader()
+
+	if err != nil {
+		if f.pool != nil {
+			f.lend(false)
+		}
+		f.fail()
+		return r, n, err
+	}
+	if f.stats.frames.Add(1); int64(n) > f.stats.maxFrame.Load() {
+		f.stats.maxFrame.Store(int64(n))
+	}
+	if f.hook != nil {
+		f.hook.FrameRead(n, n)
+	}
+	return r, n, err
+}
+
+func (f *FrameScanner) nextReader() (io.Reader, int, error) {
+	var (
+		c      byte
+		m      int
+		digits int
 	)
+	if f.began = (time.Time{}); !f.discard() {
+		return nil, 0, f.err
+	}
+_header:
+	for {
+		for ; f.start < f.end; f.start++ {
+			c = f.buf[f.start]
+
+			if m > 0 && c == ' ' {
+				if f.start++; f.skipExceeded && 0 < f.maxFrameSize && f.maxFrameSize < m {
+					f.rem = m
+					f.exceeded(m)
+
+					if !f.discard() {
+						return nil, 0, f.err
+					}
+					m, digits = 0, 0
+					continue _header
+				}
+				f.rem, f.length, f.truncated = m, m, false
+				f.framing = OctetCounting
+				f.reader.f = f
+				return &f.reader, m, nil
+			}
+			if (m == 0 && c == '0') || '0' > c || c > '9' {
+				f.err = ErrFrame
+				return nil, 0, f.err
+			}
+			if digits == maxFrameDigits {
+				f.err = ErrFrameLength
+				return nil, 0, f.err
+			}
+			m *= 10
+			m += int(c - '0')
+			digits++
+
+			if 0 < f.maxFrameSize && f.maxFrameSize < m && !f.skipExceeded {
+				f.err = ErrFrameExceeded
+				return nil, 0, f.err
+			}
+		}
+		if f.err != nil {
+			if m > 0 && f.err == io.EOF {
+				f.err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, f.err
+		}
+		if f.pool != nil && m == 0 {
+			f.lend(false)
+		}
+		f.start = 0
+		f.end, f.err = f.read(f.buf, m > 0)
+	}
+}
+
+// discard skips the rest of the current frame that has not been read.
+func (f *FrameScanner) discard() bool {
+	var n int
+
+	if f.rem > 0 {
+		if n = f.end - f.start; f.rem <= n {
+			f.start += f.rem
+		} else {
+			f.offset += f.rem - n
+			f.start = f.end
+		}
+		f.rem = 0
+	}
 	for f.offset > 0 {
-		if n, f.err = f.r.Read(f.buf); f.err != nil {
+		if f.err != nil {
+			return false
+		}
+		if n, f.err = f.read(f.buf, true); f.err != nil {
 			return false
 		}
 		f.offset -= n
@@ -35,51 +477,627 @@ func (f *FrameScanner) Next() bool {
 		f.end = n
 		f.offset = 0
 	}
+	return true
+}
+
+type frameReader struct {
+	f *FrameScanner
+}
+
+func (r *frameReader) Read(p []byte) (n int, err error) {
+	f := r.f
+
+	if f.rem == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > f.rem {
+		p = p[:f.rem]
+	}
+	if f.start < f.end {
+		n = copy(p, f.buf[f.start:f.end])
+		f.start += n
+	} else if f.err != nil {
+		err = f.err
+	} else {
+		n, err = f.read(p, true)
+	}
+	if f.rem -= n; err != nil {
+		if err == io.EOF && f.rem > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if f.err = err; err == io.EOF {
+			err = nil
+		}
+	}
+	return
+}
+
+// read reads into p from the underlying io.Reader. If the reader has read
+// deadlines, the wait is bounded by the frame timeout, when a frame is
+// partially read, and by the idle timeout otherwise.
+func (f *FrameScanner) read(p []byte, partial bool) (n int, err error) {
+	if f.ctx != nil {
+		if err = f.ctx.Err(); err != nil {
+			return
+		}
+	}
+	d, ok := f.r.(readDeadliner)
+
+	if ok = ok && (f.idleTimeout > 0 || f.frameTimeout > 0 || f.ctx != nil || f.drain != nil); ok {
+		var t time.Time
+
+		if !partial {
+			if f.began = (time.Time{}); f.idleTimeout > 0 {
+				t = time.Now().Add(f.idleTimeout)
+			}
+		} else if f.frameTimeout > 0 {
+			if f.began.IsZero() {
+				f.began = time.Now()
+			}
+			t = f.began.Add(f.frameTimeout)
+		}
+		if f.drain != nil {
+			err = f.drain.enter(partial, t)
+		} else {
+			err = d.SetReadDeadline(t)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if f.drain != nil {
+		defer func() {
+			if f.drain.leave() && !partial && err != nil {
+				err = io.EOF
+			}
+		}()
+	}
+	if ok && f.ctx != nil {
+		if err = f.ctx.Err(); err != nil {
+			return
+		}
+	}
+	n, err = f.r.Read(p)
+
+	if f.stats.bytes.Add(int64(n)); err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		switch {
+		case f.ctx != nil && f.ctx.Err() != nil:
+			err = f.ctx.Err()
+		case partial && f.frameTimeout > 0:
+			err = ErrFrameTimeout
+		case !partial && f.idleTimeout > 0:
+			err = ErrIdleTimeout
+		}
+	}
+	return
+}
+
+// interrupted reports whether err ended the wait for a frame,
+// so that the partially read frame must not be returned.
+func interrupted(err error) bool {
+	switch err {
+	case nil, io.EOF:
+		return false
+	case ErrIdleTimeout, ErrFrameTimeout, context.Canceled, context.DeadlineExceeded:
+		return true
+	}
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+var aLongTimeAgo = time.Unix(1, 0)
+
+// drain stops a FrameScanner between frames, leaving the frame being read
+// to be completed: once stopped, the scanner ends the stream at its next
+// idle read, and an idle read it is blocked in is interrupted.
+type drain struct {
+	mu   sync.Mutex
+	d    readDeadliner
+	idle bool
+	quit bool
+}
+
+// enter sets the read deadline t before the scanner reads, unless it has
+// been stopped between frames, when it returns io.EOF. The deadline is set
+// under the lock, so that it cannot undo the one stop sets.
+func (d *drain) enter(partial bool, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.quit && !partial {
+		return io.EOF
+	}
+	d.idle = !partial
+	return d.d.SetReadDeadline(t)
+}
+
+// leave reports whether the scanner has been stopped, after it reads.
+func (d *drain) leave() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.idle = false
+	return d.quit
+}
+
+func (d *drain) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.quit = true; d.idle {
+		d.d.SetReadDeadline(aLongTimeAgo)
+	}
+}
+
+// sync moves the start of buffered data to the next plausible frame
+// boundary, MSG-LEN SP "<", and reports whether it has been found.
+// Failing that, it keeps a trailing run of digits that may begin one.
+func (f *FrameScanner) sync() bool {
+	i := f.start
+
+	for ; f.inDigits && i < f.end; i++ {
+		if c := f.buf[i]; '0' > c || c > '9' {
+			f.inDigits = false
+			break
+		}
+	}
+	for i < f.end {
+		if c := f.buf[i]; '1' > c || c > '9' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < f.end && '0' <= f.buf[j] && f.buf[j] <= '9' {
+			j++
+		}
+		if j-i <= maxFrameDigits {
+			if j+1 >= f.end && (i > 0 || f.end < len(f.buf)) {
+				break
+			}
+			if j+1 < f.end && f.buf[j] == ' ' && f.buf[j+1] == '<' {
+				n := 0
+				for _, c := range f.buf[i:j] {
+					n = n*10 + int(c-'0')
+				}
+				if f.maxFrameSize <= 0 || n <= f.maxFrameSize {
+					f.skipping += i - f.start
+					f.start = i
+					return true
+				}
+			}
+		}
+		f.inDigits = j == f.end
+		i = j
+	}
+	f.skipping += i - f.start
+	f.start = i
+	return false
+}
+
+func (f *FrameScanner) Bytes() []byte {
+	return f.frame
+}
+
+func (f *FrameScanner) Err() error {
+	if f.err == io.EOF {
+		return nil
+	}
+	return f.err
+}
+
+// resize reallocates the buffer to size bytes, keeping buffered data.
+func (f *FrameScanner) resize(size int) {
+	buf := make([]byte, size)
+	f.end = copy(buf, f.buf[f.start:f.end])
+	f.start = 0
+	f.buf = buf
+	f.shift = size >> 1
+
+	if f.lent != nil {
+		f.pool.Put(f.lent)
+		f.lent = nil
+	}
+}
+
+// lend borrows a buffer from the pool for a partially read frame,
+// moving buffered data into it, and gives it back once f is idle,
+// switching to the scratch buffer.
+func (f *FrameScanner) lend(partial bool) bool {
+	if partial && !f.borrowed {
+		b, err := f.pool.get(f.ctx)
+		if err != nil {
+			f.err = err
+			return false
+		}
+		f.end = copy(b, f.buf[f.start:f.end])
+		f.start = 0
+		f.buf, f.lent, f.borrowed = b, b, true
+		f.shift = len(b) >> 1
+	} else if !partial && f.borrowed {
+		if f.lent != nil {
+			f.pool.Put(f.lent)
+			f.lent = nil
+		}
+		f.buf, f.borrowed = f.scratch, false
+		f.shift = len(f.buf) >> 1
+		f.start = 0
+		f.end = 0
+	}
+	return true
+}
+
+// Truncated reports whether the current frame is shorter than it was sent,
+// because it didn't fit into the buffer or the stream ended.
+func (f *FrameScanner) Truncated() bool {
+	return f.truncated
+}
+
+// Len returns the length of the current frame as it was sent: MSG-LEN of
+// an octet-counted frame, or -1 for a truncated non-transparent frame,
+// whose length isn't known yet.
+func (f *FrameScanner) Len() int {
+	return f.length
+}
+
+// Grow makes f reallocate its buffer when a frame doesn't fit into it,
+// up to maxFrameSize, so that full frames are returned. Without
+// maxFrameSize the buffer doesn't grow and large frames are truncated.
+func (f *FrameScanner) Grow(grow bool) {
+	f.grow = grow
+}
+
+// SkipExceeded makes f skip a frame larger than maxFrameSize instead of
+// failing with ErrFrameExceeded, and call fn, if not nil, with its size.
+// The size of a non-transparent frame is known only at its trailer, so such
+// a frame larger than the buffer may have been returned truncated by then.
+func (f *FrameScanner) SkipExceeded(fn func(size int)) {
+	f.skipExceeded = true
+	f.onExceeded = fn
+}
+
+// Resync makes f recover from ErrFrame: instead of failing, it discards
+// bytes up to the next plausible frame boundary, MSG-LEN SP "<", and calls
+// fn, if not nil, with the number of bytes discarded.
+func (f *FrameScanner) Resync(fn func(skipped int)) {
+	f.resync = true
+	f.onResync = fn
+}
+
+// SetTimeouts bounds the time f waits for the first byte of a frame by idle,
+// failing with ErrIdleTimeout, and the time it waits for the rest of a frame,
+// once its first byte has been read, by frame, failing with ErrFrameTimeout.
+// The timeouts are set as read deadlines of the underlying io.Reader, so it
+// must have SetReadDeadline, such as net.Conn. A zero timeout means none.
+func (f *FrameScanner) SetTimeouts(idle, frame time.Duration) {
+	f.idleTimeout = idle
+	f.frameTimeout = frame
+}
+
+// SetPool makes f borrow a buffer from p only while a frame is partially
+// read, waiting while the budget of p is spent, and give it back when idle,
+// when it reads into its own buffer, which then needs just a few bytes.
+// The borrowed buffer is kept until the next call of Next, as the current
+// frame is in it. Frames larger than the buffers of p are truncated or,
+// with Grow, read into a buffer of their own.
+// SetPool must be called before the first frame.
+func (f *FrameScanner) SetPool(p *BufferPool) {
+	if p.size < len(f.buf) {
+		panic(`syslogp.FrameScanner: pool buffer size must not be less than buffer size.`)
+	}
+	f.pool = p
+	f.scratch = f.buf
+}
+
+// Skipped returns the number of bytes discarded to resynchronise.
+func (f *FrameScanner) Skipped() int64 {
+	return f.stats.skipped.Load()
+}
+
+// SetHook makes f report its events to h.
+func (f *FrameScanner) SetHook(h FrameHook) {
+	f.hook = h
+}
+
+// Stats returns a snapshot of the statistics of f since it was created
+// or last reset. It is safe to call concurrently with Next.
+func (f *FrameScanner) Stats() FrameStats {
+	return FrameStats{
+		Frames:    f.stats.frames.Load(),
+		Bytes:     f.stats.bytes.Load(),
+		Truncated: f.stats.truncated.Load(),
+		Dropped:   f.stats.dropped.Load(),
+		Exceeded:  f.stats.exceeded.Load(),
+		Resyncs:   f.stats.resyncs.Load(),
+		Skipped:   f.stats.skipped.Load(),
+		MaxFrame:  f.stats.maxFrame.Load(),
+		Errors:    f.stats.errors.Load(),
+		Timeouts:  f.stats.timeouts.Load(),
+	}
+}
+
+// Framing returns the framing of the current frame.
+func (f *FrameScanner) Framing() Framing {
+	return f.framing
+}
+
+func (f *FrameScanner) Reset(r io.Reader) {
+	f.r = r
+	f.err = nil
+	f.start = 0
+	f.end = 0
+	f.offset = 0
+	f.scan = 0
+	f.size = 0
+	f.skip = false
+	f.framing = OctetCounting
+	f.length = 0
+	f.truncated = false
+	f.resyncing = false
+	f.inDigits = false
+	f.skipping = 0
+	f.rem = 0
+	f.began = time.Time{}
+	f.returned = 0
+	f.failed = false
+	f.stats.reset()
+
+	if f.pool != nil {
+		f.lend(false)
+	}
+}
+
+func NewFrameScanner(r io.Reader, buf []byte, maxFrameSize int) *FrameScanner {
+	if cap(buf) < 1 {
+		panic(`syslogp.FrameScanner: buffer capacity must be greater than zero.`)
+	}
+	return &FrameScanner{
+		r:            r,
+		buf:          buf[:cap(buf)],
+		shift:        cap(buf) >> 1,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// NewAutoFrameScanner returns a FrameScanner that detects the framing
+// of every frame by its first bytes: MSG-LEN SP starts an octet-counted
+// frame, anything else a frame delimited by trailer (RFC 6587, 3.4.2).
+// Stray LF, CR and NUL bytes between frames are skipped.
+func NewAutoFrameScanner(r io.Reader, buf []byte, maxFrameSize int, trailer Trailer) *FrameScanner {
+	if cap(buf) < 2 {
+		panic(`syslogp.FrameScanner: buffer capacity must be greater than one.`)
+	}
+	f := NewFrameScanner(r, buf, maxFrameSize)
+	f.trailer = trailer.bytes()
+	return f
+}
+
+// FrameStats are the statistics of a FrameScanner.
+type FrameStats struct {
+	Frames    int64 // frames returned
+	Bytes     int64 // bytes read
+	Truncated int64 // frames returned truncated
+	Dropped   int64 // bytes of truncated frames discarded
+	Exceeded  int64 // frames skipped as larger than maxFrameSize
+	Resyncs   int64 // resynchronisations after ErrFrame
+	Skipped   int64 // bytes discarded to resynchronise
+	MaxFrame  int64 // length of the largest frame as it was sent
+	Errors    int64 // errors that ended the stream
+	Timeouts  int64 // idle and frame timeouts
+}
+
+type frameStats struct {
+	frames, bytes, truncated, dropped, exceeded  atomic.Int64
+	resyncs, skipped, maxFrame, errors, timeouts atomic.Int64
+}
+
+func (s *frameStats) reset() {
+	for _, v := range [...]*atomic.Int64{
+		&s.frames, &s.bytes, &s.truncated, &s.dropped, &s.exceeded,
+		&s.resyncs, &s.skipped, &s.maxFrame, &s.errors, &s.timeouts,
+	} {
+		v.Store(0)
+	}
+}
+
+// FrameHook receives the events of a FrameScanner, such as to export
+// metrics. Its methods are called by Next and NextReader.
+type FrameHook interface {
+	// FrameRead is called for every frame returned, with its size and
+	// its length as it was sent, which is -1 if it isn't known yet.
+	FrameRead(size, length int)
+	// FrameSkipped is called when n bytes are skipped: a frame larger than
+	// maxFrameSize, with ErrFrameExceeded, or bytes discarded to
+	// resynchronise, with ErrFrame.
+	FrameSkipped(n int, reason error)
+	// FrameError is called with the error that ended the stream.
+	FrameError(err error)
+}
+
+// BufferPool lends buffers of the same size to FrameScanners
+// within a memory budget. It is safe for concurrent use.
+type BufferPool struct {
+	size int
+	sem  chan struct{}
+	free chan []byte
+}
+
+// Get returns a buffer, waiting while the budget is spent.
+func (p *BufferPool) Get() []byte {
+	b, _ := p.get(nil)
+	return b
+}
+
+func (p *BufferPool) get(ctx context.Context) ([]byte, error) {
+	var done <-chan struct{}
+
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case p.sem <- struct{}{}:
+	case <-done:
+		return nil, ctx.Err()
+	}
+	select {
+	case b := <-p.free:
+		return b, nil
+	default:
+		return make([]byte, p.size), nil
+	}
+}
+
+// Put gives back a buffer returned by Get.
+func (p *BufferPool) Put(b []byte) {
+	select {
+	case p.free <- b[:p.size]:
+	default:
+	}
+	<-p.sem
+}
+
+// InUse returns the number of buffers lent.
+func (p *BufferPool) InUse() int {
+	return len(p.sem)
+}
+
+// NewBufferPool returns a pool of buffers of size bytes, which lends
+// no more of them at once than fit into budget bytes, but at least one.
+func NewBufferPool(size, budget int) *BufferPool {
+	if size < 1 {
+		panic(`syslogp.BufferPool: buffer size must be greater than zero.`)
+	}
+	n := max(budget/size, 1)
+
+	return &BufferPool{
+		size: size,
+		sem:  make(chan struct{}, n),
+		free: make(chan []byte, n),
+	}
+}
+
+// Framing is the method of framing syslog messages in a stream (RFC 6587, 3.4).
+type Framing uint8
+
+const (
+	OctetCounting Framing = iota
+	NonTransparent
+)
+
+func (m Framing) String() string {
+	switch m {
+	case OctetCounting:
+		return `octet-counting`
+	case NonTransparent:
+		return `non-transparent`
+	}
+	return `unknown`
+}
+
+// Trailer is the delimiter of frames in non-transparent framing (RFC 6587, 3.4.2).
+type Trailer uint8
+
+const (
+	TrailerLF   Trailer = iota // %d10
+	TrailerNUL                 // %d00
+	TrailerCRLF                // %d13 %d10
+)
+
+func (t Trailer) bytes() []byte {
+	switch t {
+	case TrailerNUL:
+		return []byte{0}
+	case TrailerCRLF:
+		return []byte{'\r', '\n'}
+	}
+	return []byte{'\n'}
+}
+
+func (t Trailer) String() string {
+	switch t {
+	case TrailerLF:
+		return `LF`
+	case TrailerNUL:
+		return `NUL`
+	case TrailerCRLF:
+		return `CRLF`
+	}
+	return `unknown`
+}
+
+// NonTransparentScanner reads frames delimited by a trailer.
+// Empty frames are skipped. A frame larger than the buffer is truncated
+// to the buffer size and the rest of it, up to the trailer, is discarded.
+type NonTransparentScanner struct {
+	r            io.Reader
+	err          error
+	buf          []byte
+	frame        []byte
+	trailer      []byte
+	start        int
+	end          int
+	scan         int
+	size         int
+	shift        int
+	maxFrameSize int
+	skip         bool
+	unterminated bool
+}
+
+func (f *NonTransparentScanner) Next() bool {
+	var n int
+
+	f.unterminated = false
+
 	for {
 		if f.end > f.start {
-			if frameSize == 0 {
-				for ; f.start < f.end; f.start++ {
-					c = f.buf[f.start]
-
-					if m > 0 && c == ' ' {
-						m, frameSize = 0, m
-						f.start++
-						break
-					}
-					if (m == 0 && c == '0') || '0' > c || c > '9' {
-						f.err = ErrFrame
-						return false
-					}
-					m *= 10
-					m += int(c - '0')
+			if i := trailerIndex(f.buf, f.trailer, f.start, f.end, &f.scan); i >= 0 {
+				f.frame = f.buf[f.start:i]
+				f.start = i + len(f.trailer)
+				f.size += len(f.frame)
 
-					if 0 < f.maxFrameSize && f.maxFrameSize < m {
-						f.err = ErrFrameExceeded
-						return false
-					}
+				if n, f.size = f.size, 0; 0 < f.maxFrameSize && f.maxFrameSize < n {
+					f.err = ErrFrameExceeded
+					return false
 				}
-				if frameSize > len(f.buf) {
-					f.offset = frameSize - len(f.buf)
-					frameSize = len(f.buf)
+				if f.skip {
+					f.skip = false
+					continue
 				}
+				if len(f.frame) > 0 {
+					return true
+				}
+				continue
 			}
-			n = f.end - f.start
-
-			if frameSize > n && f.err != nil {
-				frameSize = n
-			}
-			if 0 < frameSize && frameSize <= n {
-				frameSize += f.start
-				f.frame = f.buf[f.start:frameSize]
-				f.start = frameSize
-				return true
+			if 0 < f.maxFrameSize && f.maxFrameSize < f.size+f.scan {
+				f.err = ErrFrameExceeded
+				return false
 			}
 		}
 		if f.err != nil {
+			if f.end > f.start && !f.skip {
+				f.frame = f.buf[f.start:f.end]
+				f.unterminated = true
+			}
 			f.start = 0
 			f.end = 0
-			f.offset = 0
-			return false
+			f.scan = 0
+			f.size = 0
+			return f.unterminated
+		}
+		if f.start == 0 && f.end == len(f.buf) {
+			if n = f.end - len(f.trailer) + 1; f.skip {
+				f.start, f.size = n, f.size+n
+				f.scan = 0
+				continue
+			}
+			f.frame = f.buf[:n]
+			f.start, f.size = n, n
+			f.scan = 0
+			f.skip = true
+			return true
 		}
 		if f.start > 0 && (f.end == len(f.buf) || f.start >= f.shift) {
 			copy(f.buf, f.buf[f.start:f.end])
@@ -91,32 +1109,57 @@ func (f *FrameScanner) Next() bool {
 	}
 }
 
-func (f *FrameScanner) Bytes() []byte {
+// trailerIndex returns the index in buf of the first trailer in buf[start:end],
+// or -1. The search resumes at *scan, which is set past the bytes that
+// cannot begin a trailer, so that they are not searched again.
+func trailerIndex(buf, trailer []byte, start, end int, scan *int) int {
+	if i := bytes.Index(buf[start+*scan:end], trailer); i >= 0 {
+		i += start + *scan
+		*scan = 0
+		return i
+	}
+	if *scan = end - start - len(trailer) + 1; *scan < 0 {
+		*scan = 0
+	}
+	return -1
+}
+
+func (f *NonTransparentScanner) Bytes() []byte {
 	return f.frame
 }
 
-func (f *FrameScanner) Err() error {
+// Unterminated reports whether the current frame
+// is the final one that ended without a trailer.
+func (f *NonTransparentScanner) Unterminated() bool {
+	return f.unterminated
+}
+
+func (f *NonTransparentScanner) Err() error {
 	if f.err == io.EOF {
 		return nil
 	}
 	return f.err
 }
 
-func (f *FrameScanner) Reset(r io.Reader) {
+func (f *NonTransparentScanner) Reset(r io.Reader) {
 	f.r = r
 	f.err = nil
 	f.start = 0
 	f.end = 0
-	f.offset = 0
+	f.scan = 0
+	f.size = 0
+	f.skip = false
+	f.unterminated = false
 }
 
-func NewFrameScanner(r io.Reader, buf []byte, maxFrameSize int) *FrameScanner {
-	if cap(buf) < 1 {
-		panic(`syslogp.FrameScanner: buffer capacity must be greater than zero.`)
+func NewNonTransparentScanner(r io.Reader, buf []byte, trailer Trailer, maxFrameSize int) *NonTransparentScanner {
+	if cap(buf) < 2 {
+		panic(`syslogp.NonTransparentScanner: buffer capacity must be greater than one.`)
 	}
-	return &FrameScanner{
+	return &NonTransparentScanner{
 		r:            r,
 		buf:          buf[:cap(buf)],
+		trailer:      trailer.bytes(),
 		shift:        cap(buf) >> 1,
 		maxFrameSize: maxFrameSize,
 	}
@@ -127,6 +1170,14 @@ type FrameWriter struct {
 	buf     []byte
 	offset  int
 	scratch [20]byte
+	trailer []byte
+	policy  TrailerPolicy
+	hdr     []byte
+	vec     net.Buffers
+	max     int
+	cut     bool
+	marker  []byte
+	trunc   []byte
 }
 
 // Reset discards all unflushed buffered frames and
@@ -161,21 +1212,26 @@ func (f *FrameWriter) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return
 	}
+	if f.max > 0 && f.size(p) > f.max {
+		if !f.cut {
+			return 0, ErrFrameExceeded
+		}
+		p = f.truncate(p)
+	}
+	if f.trailer != nil {
+		return f.writeTrailer(p)
+	}
 	h := strconv.AppendInt(f.scratch[:0], int64(len(p)), 10)
 	m := len(h)
 
 	h = h[:m+1]
 	h[m] = ' '
 
-	if m += len(p) + 1; m > len(f.buf)-f.offset {
-		if err = f.Flush(); err == nil && m > len(f.buf) {
-			if n, err = f.write(h); err == nil {
-				a := 0
-				a, err = f.write(p)
-				n += a
-			}
-			return
-		}
+	if m += len(p) + 1; m > len(f.buf) {
+		return f.writev(append(f.vector(), h, p), m)
+	}
+	if m > len(f.buf)-f.offset {
+		err = f.Flush()
 	}
 	if err == nil {
 		n = copy(f.buf[f.offset:], h)
@@ -185,6 +1241,283 @@ func (f *FrameWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// WriteFrames writes frames, skipping empty ones, in one go: they are
+// buffered if they fit, otherwise they are written along with the buffered
+// frames by a single vectored write, which is writev(2) for net.Conn.
+// Its returns the number of bytes written and any error.
+func (f *FrameWriter) WriteFrames(frames [][]byte) (n int, err error) {
+	var a, i, j, m int
+
+	if f.trailer != nil || f.exceeds(frames) {
+		for _, p := range frames {
+			a, err = f.Write(p)
+
+			if n += a; err != nil {
+				break
+			}
+		}
+		return
+	}
+	f.hdr = f.hdr[:0]
+
+	for _, p := range frames {
+		if len(p) > 0 {
+			f.hdr = strconv.AppendInt(f.hdr, int64(len(p)), 10)
+			f.hdr = append(f.hdr, ' ')
+			m += len(p)
+		}
+	}
+	if m += len(f.hdr); m <= len(f.buf)-f.offset {
+		for _, p := range frames {
+			if len(p) > 0 {
+				j = i + bytes.IndexByte(f.hdr[i:], ' ') + 1
+				f.offset += copy(f.buf[f.offset:], f.hdr[i:j])
+				f.offset += copy(f.buf[f.offset:], p)
+				i = j
+			}
+		}
+		return m, nil
+	}
+	v := f.vector()
+
+	for _, p := range frames {
+		if len(p) > 0 {
+			j = i + bytes.IndexByte(f.hdr[i:], ' ') + 1
+			v = append(v, f.hdr[i:j], p)
+			i = j
+		}
+	}
+	return f.writev(v, m)
+}
+
+// SetMaxFrameSize makes f fail writing a frame larger than size bytes,
+// as framed, with ErrFrameExceeded, unless it is truncated.
+func (f *FrameWriter) SetMaxFrameSize(size int) {
+	f.max = size
+}
+
+// TruncateExceeded makes f truncate a frame larger than the max frame size
+// and end it with marker, if not empty. The frame is cut at a character
+// boundary and, within STRUCTURED-DATA of an RFC 5424 message, after
+// the last SD-ELEMENT that fits, in which case marker becomes MSG.
+func (f *FrameWriter) TruncateExceeded(marker []byte) {
+	f.cut = true
+	f.marker = marker
+}
+
+// exceeds reports whether any of frames is larger than the max frame size.
+func (f *FrameWriter) exceeds(frames [][]byte) bool {
+	if f.max > 0 {
+		for _, p := range frames {
+			if f.size(p) > f.max {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// size returns the size of p as framed, without a header or trailer.
+func (f *FrameWriter) size(p []byte) int {
+	if f.trailer != nil && f.policy == TrailerEscape {
+		return len(p) + 3*bytes.Count(p, f.trailer[len(f.trailer)-1:])
+	}
+	return len(p)
+}
+
+// fit returns the length of the longest prefix of p that takes up
+// to n bytes as framed.
+func (f *FrameWriter) fit(p []byte, n int) int {
+	if f.trailer == nil || f.policy != TrailerEscape {
+		return max(min(len(p), n), 0)
+	}
+	c := f.trailer[len(f.trailer)-1]
+
+	for i := range p {
+		if n--; p[i] == c {
+			n -= 3
+		}
+		if n < 0 {
+			return i
+		}
+	}
+	return len(p)
+}
+
+// truncate returns p cut to fit into the max frame size along with marker,
+// which is left out if it doesn't fit itself.
+func (f *FrameWriter) truncate(p []byte) []byte {
+	marker := f.marker
+
+	if f.size(marker) > f.max {
+		marker = nil
+	}
+	n := f.max - f.size(marker)
+	k := f.fit(p, n)
+
+	for k > 0 && !utf8.RuneStart(p[k]) {
+		k--
+	}
+	f.trunc = f.trunc[:0]
+
+	if s := structDataPos(p); 0 <= s && s < k && p[s] == '[' {
+		if e := structDataCut(p, s, f.fit(p, n-1)); e > s || (e == s && s < n-1) {
+			if f.trunc = append(f.trunc, p[:e]...); e == s {
+				f.trunc = append(f.trunc, '-')
+			}
+			if len(marker) > 0 {
+				f.trunc = append(f.trunc, ' ')
+				f.trunc = append(f.trunc, marker...)
+			}
+			return f.trunc
+		}
+	}
+	f.trunc = append(f.trunc, p[:k]...)
+	return append(f.trunc, marker...)
+}
+
+// structDataPos returns the position of STRUCTURED-DATA
+// in an RFC 5424 message, or -1 if p isn't one.
+func structDataPos(p []byte) int {
+	pos := 0
+
+	for _, scan := range [...]func([]byte, *int) ([]byte, error){
+		ScanPriority, ScanVersion, ScanTimestamp, ScanHostname, ScanAppName, ScanProcId, ScanMsgId,
+	} {
+		if _, err := scan(p, &pos); err != nil {
+			return -1
+		}
+	}
+	return pos
+}
+
+// structDataCut returns the end of the last SD-ELEMENT of STRUCTURED-DATA
+// at s in p that ends within k bytes, or -1 if the whole section does.
+func structDataCut(p []byte, s, k int) int {
+	last, quoted := s, false
+
+	for i := s; i < len(p); i++ {
+		switch c := p[i]; {
+		case quoted:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				quoted = false
+			}
+		case c == '"':
+			quoted = true
+		case c == ']':
+			if i >= k {
+				return last
+			}
+			if last = i + 1; last == len(p) || p[last] != '[' {
+				return -1
+			}
+		}
+	}
+	return last
+}
+
+// vector returns the vector of buffers to write, starting with
+// the buffered frames.
+func (f *FrameWriter) vector() net.Buffers {
+	if f.offset > 0 {
+		return append(f.vec[:0], f.buf[:f.offset])
+	}
+	return f.vec[:0]
+}
+
+// writev writes v, the buffered frames followed by m bytes of new ones.
+// It returns the number of bytes of new frames written and keeps the
+// buffered frames that have not been written.
+func (f *FrameWriter) writev(v net.Buffers, m int) (n int, err error) {
+	k := f.offset
+	f.vec = v
+
+	w, err := v.WriteTo(f.w)
+	clear(f.vec)
+
+	if n = int(w); n < k+m && err == nil {
+		err = io.ErrShortWrite
+	}
+	if n < k {
+		if n > 0 {
+			copy(f.buf[0:], f.buf[n:k])
+		}
+		f.offset -= n
+		return 0, err
+	}
+	f.offset = 0
+	return n - k, err
+}
+
+// writeTrailer writes p delimited by the trailer, applying the policy
+// to the trailer bytes in it.
+func (f *FrameWriter) writeTrailer(p []byte) (n int, err error) {
+	var (
+		c = f.trailer[len(f.trailer)-1]
+		k = bytes.Count(p, f.trailer[len(f.trailer)-1:])
+		m = len(p) + len(f.trailer)
+	)
+	if k > 0 {
+		switch f.policy {
+		case TrailerReject:
+			return 0, ErrTrailerInFrame
+		case TrailerEscape:
+			m += 3 * k
+		}
+	}
+	if m > len(f.buf)-f.offset {
+		if err = f.Flush(); err != nil {
+			return
+		}
+	}
+	for k > 0 {
+		i := bytes.IndexByte(p, c)
+		if err = f.put(p[:i]); err != nil {
+			return
+		}
+		n += i
+
+		if f.policy == TrailerEscape {
+			err = f.put([]byte{'#', '0' + c>>6, '0' + c>>3&7, '0' + c&7})
+			n += 4
+		} else {
+			err = f.put([]byte{' '})
+			n++
+		}
+		if err != nil {
+			return
+		}
+		p, k = p[i+1:], k-1
+	}
+	if err = f.put(p); err == nil {
+		err = f.put(f.trailer)
+		n += len(p) + len(f.trailer)
+	}
+	return
+}
+
+// put copies p into the buffer, flushing it when full,
+// or writes p if there is no buffer.
+func (f *FrameWriter) put(p []byte) (err error) {
+	if len(f.buf) == 0 {
+		_, err = f.write(p)
+		return
+	}
+	for len(p) > 0 {
+		if f.offset == len(f.buf) {
+			if err = f.Flush(); err != nil {
+				return
+			}
+		}
+		n := copy(f.buf[f.offset:], p)
+		f.offset += n
+		p = p[n:]
+	}
+	return
+}
+
 func (f *FrameWriter) write(p []byte) (n int, err error) {
 	if n, err = f.w.Write(p); err == nil && n < len(p) {
 		err = io.ErrShortWrite
@@ -201,7 +1534,359 @@ func NewFrameWriter(w io.Writer, buf []byte) *FrameWriter {
 	return &FrameWriter{w: w, buf: buf}
 }
 
+// NewNonTransparentWriter returns a FrameWriter that delimits frames
+// by trailer instead of counting octets (RFC 6587, 3.4.2). The trailer byte,
+// LF or NUL, in a frame is treated according to policy.
+func NewNonTransparentWriter(w io.Writer, buf []byte, trailer Trailer, policy TrailerPolicy) *FrameWriter {
+	f := NewFrameWriter(w, buf)
+	f.trailer = trailer.bytes()
+	f.policy = policy
+	return f
+}
+
+// TrailerPolicy is what a FrameWriter does with the trailer byte
+// in a frame, which would split it otherwise.
+type TrailerPolicy uint8
+
+const (
+	TrailerEscape  TrailerPolicy = iota // escape it with its octal code, #012 for LF, as rsyslog does
+	TrailerReplace                      // replace it with a space
+	TrailerReject                       // fail with ErrTrailerInFrame
+)
+
+// TimedFrameWriter is a FrameWriter safe for concurrent use that flushes
+// buffered frames once they have waited for the interval, so that they
+// don't linger in the buffer when frames are written rarely.
+type TimedFrameWriter struct {
+	mu       sync.Mutex
+	fw       *FrameWriter
+	timer    *time.Timer
+	interval time.Duration
+	armed    bool
+	closed   bool
+	err      error
+}
+
+// Write writes p as FrameWriter does. It returns the error of a flush
+// in the background, if any, before writing p.
+func (t *TimedFrameWriter) Write(p []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err = t.check(); err == nil {
+		n, err = t.fw.Write(p)
+		t.arm()
+	}
+	return
+}
+
+// WriteFrames writes frames as FrameWriter does.
+func (t *TimedFrameWriter) WriteFrames(frames [][]byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err = t.check(); err == nil {
+		n, err = t.fw.WriteFrames(frames)
+		t.arm()
+	}
+	return
+}
+
+// Flush writes all buffered frames to the underlying io.Writer.
+func (t *TimedFrameWriter) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+	return t.fw.Flush()
+}
+
+// Close stops the timer and flushes buffered frames, even if a flush in
+// the background has failed, returning that error first.
+// Writes fail with ErrWriterClosed afterwards.
+func (t *TimedFrameWriter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.check()
+	if err == ErrWriterClosed {
+		return err
+	}
+	if t.closed = true; t.timer != nil {
+		t.timer.Stop()
+	}
+	if e := t.fw.Flush(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// check returns ErrWriterClosed or, once, the error of a flush in the background.
+func (t *TimedFrameWriter) check() (err error) {
+	if t.closed {
+		return ErrWriterClosed
+	}
+	err, t.err = t.err, nil
+	return
+}
+
+// arm starts the timer if there are buffered frames.
+func (t *TimedFrameWriter) arm() {
+	if t.armed || t.fw.offset == 0 {
+		return
+	}
+	if t.armed = true; t.timer == nil {
+		t.timer = time.AfterFunc(t.interval, t.flush)
+	} else {
+		t.timer.Reset(t.interval)
+	}
+}
+
+func (t *TimedFrameWriter) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.armed = false; !t.closed {
+		if err := t.fw.Flush(); err != nil {
+			t.err = err
+		}
+	}
+}
+
+// NewTimedFrameWriter returns a TimedFrameWriter that writes through fw
+// and flushes frames buffered for the interval.
+func NewTimedFrameWriter(fw *FrameWriter, interval time.Duration) *TimedFrameWriter {
+	if interval <= 0 {
+		panic(`syslogp.TimedFrameWriter: interval must be greater than zero.`)
+	}
+	return &TimedFrameWriter{fw: fw, interval: interval}
+}
+
+// AsyncFrameWriter writes frames through a FrameWriter from a goroutine
+// of its own, taking them from a bounded queue, so that it is safe for
+// concurrent use and callers don't wait for writes. The FrameWriter flushes
+// when its buffer is full and, given an interval, when buffered frames have
+// waited that long.
+type AsyncFrameWriter struct {
+	fw       *FrameWriter
+	queue    chan *[]byte
+	flush    chan chan error
+	done     chan struct{}
+	policy   OverflowPolicy
+	interval time.Duration
+	mu       sync.RWMutex
+	closed   bool
+	err      atomic.Value
+	dropped  atomic.Int64
+	pool     sync.Pool
+}
+
+// Write copies p into the queue, treating a full queue according to
+// the overflow policy. It returns len(p) once p is queued, or the error
+// of an earlier write, which stops the writer.
+func (a *AsyncFrameWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return 0, ErrWriterClosed
+	}
+	if err = a.Err(); err != nil {
+		return
+	}
+	b, _ := a.pool.Get().(*[]byte)
+	if b == nil {
+		b = new([]byte)
+	}
+	*b = append((*b)[:0], p...)
+
+	switch a.policy {
+	case OverflowBlock:
+		a.queue <- b
+	case OverflowDropNewest:
+		select {
+		case a.queue <- b:
+		default:
+			a.pool.Put(b)
+			a.dropped.Add(1)
+			return 0, ErrFrameDropped
+		}
+	case OverflowDropOldest:
+		for sent := false; !sent; {
+			select {
+			case a.queue <- b:
+				sent = true
+			default:
+				select {
+				case old := <-a.queue:
+					a.pool.Put(old)
+					a.dropped.Add(1)
+				default:
+				}
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush waits until the frames queued so far are written and flushed.
+func (a *AsyncFrameWriter) Flush() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return ErrWriterClosed
+	}
+	c := make(chan error, 1)
+	a.flush <- c
+	return <-c
+}
+
+// Close writes and flushes the queued frames and stops the writer.
+// It returns the first error of writing.
+func (a *AsyncFrameWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrWriterClosed
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	<-a.done
+	return a.Err()
+}
+
+// Err returns the first error of writing.
+func (a *AsyncFrameWriter) Err() error {
+	err, _ := a.err.Load().(error)
+	return err
+}
+
+// Dropped returns the number of frames dropped from the queue when full,
+// as larger than the max frame size of the FrameWriter, or after an error
+// of writing.
+func (a *AsyncFrameWriter) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Queued returns the number of frames in the queue.
+func (a *AsyncFrameWriter) Queued() int {
+	return len(a.queue)
+}
+
+func (a *AsyncFrameWriter) run() {
+	var (
+		tick     <-chan time.Time
+		buffered bool
+	)
+	defer close(a.done)
+
+	if a.interval > 0 {
+		t := time.NewTicker(a.interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case b, ok := <-a.queue:
+			if !ok {
+				a.fail(a.fw.Flush())
+				return
+			}
+			a.write(b)
+			buffered = true
+		case c := <-a.flush:
+		_drain:
+			for {
+				select {
+				case b, ok := <-a.queue:
+					if !ok {
+						break _drain
+					}
+					a.write(b)
+				default:
+					break _drain
+				}
+			}
+			a.fail(a.fw.Flush())
+			c <- a.Err()
+			buffered = false
+		case <-tick:
+			if buffered {
+				a.fail(a.fw.Flush())
+				buffered = false
+			}
+		}
+	}
+}
+
+func (a *AsyncFrameWriter) write(b *[]byte) {
+	if a.Err() == nil {
+		if _, err := a.fw.Write(*b); err == ErrFrameExceeded {
+			a.dropped.Add(1)
+		} else {
+			a.fail(err)
+		}
+	} else {
+		a.dropped.Add(1)
+	}
+	a.pool.Put(b)
+}
+
+func (a *AsyncFrameWriter) fail(err error) {
+	if err != nil && a.Err() == nil {
+		a.err.Store(err)
+	}
+}
+
+// NewAsyncFrameWriter returns an AsyncFrameWriter that writes through fw
+// frames queued up to size, handling overflow by policy, and flushes fw
+// at the interval, unless it is zero.
+func NewAsyncFrameWriter(fw *FrameWriter, size int, policy OverflowPolicy, interval time.Duration) *AsyncFrameWriter {
+	if size < 1 {
+		panic(`syslogp.AsyncFrameWriter: queue size must be greater than zero.`)
+	}
+	a := &AsyncFrameWriter{
+		fw:       fw,
+		queue:    make(chan *[]byte, size),
+		flush:    make(chan chan error),
+		done:     make(chan struct{}),
+		policy:   policy,
+		interval: interval,
+	}
+	go a.run()
+	return a
+}
+
+// OverflowPolicy is what an AsyncFrameWriter does with a frame
+// when its queue is full.
+type OverflowPolicy uint8
+
+const (
+	OverflowBlock      OverflowPolicy = iota // wait for room in the queue
+	OverflowDropNewest                       // drop the frame, failing with ErrFrameDropped
+	OverflowDropOldest                       // drop the oldest frame in the queue
+)
+
+// maxFrameDigits bounds the number of digits in MSG-LEN,
+// which keeps it under 1 GB and far from overflowing int.
+const maxFrameDigits = 9
+
 var (
 	ErrFrame         = errors.New(`invalid frame`)
 	ErrFrameExceeded = errors.New(`frame size exceeded`)
+	ErrFrameLength   = errors.New(`frame length too long`)
+	ErrIdleTimeout   = errors.New(`idle timeout`)
+	ErrFrameTimeout  = errors.New(`frame timeout`)
+
+	ErrTrailerInFrame = errors.New(`trailer in frame`)
+	ErrFrameDropped   = errors.New(`frame dropped`)
+	ErrWriterClosed   = errors.New(`writer closed`)
 )
@@ -2,9 +2,17 @@ package syslogp
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func Test_FrameWriter(t *testing.T) {
@@ -44,6 +52,344 @@ func Test_FrameWriter(t *testing.T) {
 	}
 }
 
+type limitWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitWriter) Write(p []byte) (n int, err error) {
+	if len(p) > l.n {
+		p, err = p[:l.n], io.ErrClosedPipe
+	}
+	n, _ = l.w.Write(p)
+	l.n -= n
+	return
+}
+
+func Test_FrameWriterWriteFrames(t *testing.T) {
+	cases := [...]struct {
+		in      [][]byte
+		bufSize int
+	}{
+		{[][]byte{}, 0},
+		{[][]byte{nil, []byte(`first`), []byte{}, []byte(`second`)}, 0},
+		{[][]byte{[]byte(`first`), []byte(`second`)}, 8},
+		{[][]byte{[]byte(`first`), []byte(`second`)}, 16},
+		{[][]byte{[]byte(`first`), []byte(`second`), []byte(`third`)}, 128},
+	}
+	for _, c := range cases {
+		var exp []byte
+
+		b := new(bytes.Buffer)
+		w := NewFrameWriter(b, make([]byte, c.bufSize))
+		w.Write([]byte(`zero`))
+		exp = appendFrame(exp, []byte(`zero`))
+
+		for _, frame := range c.in {
+			exp = appendFrame(exp, frame)
+		}
+		n, err := w.WriteFrames(c.in)
+		w.Flush()
+
+		if out := b.Bytes(); err != nil || !bytes.Equal(exp, out) || n != len(exp)-6 {
+			t.Errorf("\n\tfor: %q, buffer size = %d\n\texp: %q, %d\n\tgot: %q, %d, %v\n", c.in, c.bufSize, exp, len(exp)-6, out, n, err)
+		}
+	}
+	b := new(bytes.Buffer)
+	l := &limitWriter{b, 3}
+	w := NewFrameWriter(l, make([]byte, 16))
+	w.Write([]byte(`first`))
+
+	if n, err := w.WriteFrames([][]byte{[]byte(`second`), []byte(`third-frame`)}); n != 0 || err != io.ErrClosedPipe {
+		t.Errorf("\n\tfor: short write\n\texp: 0, %v\n\tgot: %d, %v\n", io.ErrClosedPipe, n, err)
+	}
+	l.n = 1 << 10
+
+	if err := w.Flush(); err != nil || b.String() != `5 first` {
+		t.Errorf("\n\tfor: flush after short write\n\texp: %q\n\tgot: %q, %v\n", `5 first`, b.String(), err)
+	}
+}
+
+type gateWriter struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (g *gateWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.b.Write(p)
+}
+
+func (g *gateWriter) String() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.b.String()
+}
+
+// flakyWriter fails its first writes, then writes as gateWriter.
+type flakyWriter struct {
+	gateWriter
+	fails int
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	if f.fails > 0 {
+		f.fails--
+		f.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	f.mu.Unlock()
+	return f.gateWriter.Write(p)
+}
+
+func (f *flakyWriter) failing() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fails > 0
+}
+
+func Test_AsyncFrameWriter(t *testing.T) {
+	const writers, frames = 8, 100
+
+	g := new(gateWriter)
+	a := NewAsyncFrameWriter(NewFrameWriter(g, make([]byte, 64)), 4, OverflowBlock, 0)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < frames; j++ {
+				a.Write([]byte(fmt.Sprintf(`<%d>%d`, i, j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := a.Close(); err != nil {
+		t.Errorf("\n\tfor: Close\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	seen := map[string]bool{}
+	f := NewFrameScanner(bytes.NewReader([]byte(g.String())), make([]byte, 16), 0)
+
+	for f.Next() {
+		seen[string(f.Bytes())] = true
+	}
+	if len(seen) != writers*frames || a.Dropped() != 0 {
+		t.Errorf("\n\tfor: %d writers\n\texp: %d frames\n\tgot: %d, %d dropped, %v\n", writers, writers*frames, len(seen), a.Dropped(), f.Err())
+	}
+	if _, err := a.Write([]byte(`late`)); err != ErrWriterClosed {
+		t.Errorf("\n\tfor: Write after Close\n\texp: %v\n\tgot: %v\n", ErrWriterClosed, err)
+	}
+}
+
+func Test_TimedFrameWriter(t *testing.T) {
+	const writers, frames = 8, 100
+
+	g := new(gateWriter)
+	w := NewTimedFrameWriter(NewFrameWriter(g, make([]byte, 64)), 20*time.Millisecond)
+	w.Write([]byte(`first`))
+
+	if out := g.String(); out != `` {
+		t.Errorf("\n\tfor: buffered frame\n\texp: %q\n\tgot: %q\n", ``, out)
+	}
+	for i := 0; g.String() == `` && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if out := g.String(); out != `5 first` {
+		t.Errorf("\n\tfor: flush interval\n\texp: %q\n\tgot: %q\n", `5 first`, out)
+	}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < frames; j++ {
+				w.Write([]byte(fmt.Sprintf(`<%d>%d`, i, j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := w.Close(); err != nil {
+		t.Errorf("\n\tfor: Close\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	n, f := 0, NewFrameScanner(bytes.NewReader([]byte(g.String())), make([]byte, 16), 0)
+
+	for ; f.Next(); n++ {
+	}
+	if n != writers*frames+1 || f.Err() != nil {
+		t.Errorf("\n\tfor: %d writers\n\texp: %d frames\n\tgot: %d, %v\n", writers, writers*frames+1, n, f.Err())
+	}
+	if _, err := w.Write([]byte(`late`)); err != ErrWriterClosed {
+		t.Errorf("\n\tfor: Write after Close\n\texp: %v\n\tgot: %v\n", ErrWriterClosed, err)
+	}
+}
+
+func Test_TimedFrameWriterCloseAfterError(t *testing.T) {
+	g := &flakyWriter{fails: 1}
+	w := NewTimedFrameWriter(NewFrameWriter(g, make([]byte, 64)), 10*time.Millisecond)
+	w.Write([]byte(`first`))
+
+	for i := 0; g.failing() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := w.Close(); err != io.ErrClosedPipe {
+		t.Errorf("\n\tfor: Close after a failed flush\n\texp: %v\n\tgot: %v\n", io.ErrClosedPipe, err)
+	}
+	if out := g.String(); out != `5 first` {
+		t.Errorf("\n\tfor: final flush\n\texp: %q\n\tgot: %q\n", `5 first`, out)
+	}
+	if _, err := w.Write([]byte(`late`)); err != ErrWriterClosed {
+		t.Errorf("\n\tfor: Write after Close\n\texp: %v\n\tgot: %v\n", ErrWriterClosed, err)
+	}
+	if err := w.Close(); err != ErrWriterClosed {
+		t.Errorf("\n\tfor: Close twice\n\texp: %v\n\tgot: %v\n", ErrWriterClosed, err)
+	}
+}
+
+func Test_AsyncFrameWriterOverflow(t *testing.T) {
+	cases := [...]struct {
+		policy OverflowPolicy
+		exp    string
+		err    error
+	}{
+		{OverflowDropNewest, `1 11 21 3`, ErrFrameDropped},
+		{OverflowDropOldest, `1 11 31 4`, nil},
+	}
+	for _, c := range cases {
+		var err error
+
+		g := new(gateWriter)
+		a := NewAsyncFrameWriter(NewFrameWriter(g, nil), 2, c.policy, 0)
+		g.mu.Lock()
+		a.Write([]byte(`1`))
+
+		for a.Queued() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for _, p := range []string{`2`, `3`, `4`} {
+			if _, e := a.Write([]byte(p)); e != nil {
+				err = e
+			}
+		}
+		g.mu.Unlock()
+		a.Close()
+
+		if out := g.String(); out != c.exp || err != c.err || a.Dropped() != 1 {
+			t.Errorf("\n\tfor: policy %d\n\texp: %q, %v, 1 dropped\n\tgot: %q, %v, %d dropped\n", c.policy, c.exp, c.err, out, err, a.Dropped())
+		}
+	}
+}
+
+func Test_AsyncFrameWriterFlush(t *testing.T) {
+	g := new(gateWriter)
+	a := NewAsyncFrameWriter(NewFrameWriter(g, make([]byte, 64)), 4, OverflowBlock, 10*time.Millisecond)
+	defer a.Close()
+	a.Write([]byte(`first`))
+
+	for i := 0; g.String() == `` && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if out := g.String(); out != `5 first` {
+		t.Errorf("\n\tfor: flush interval\n\texp: %q\n\tgot: %q\n", `5 first`, out)
+	}
+	a.Write([]byte(`second`))
+
+	if err := a.Flush(); err != nil || g.String() != `5 first6 second` {
+		t.Errorf("\n\tfor: Flush\n\texp: %q\n\tgot: %q, %v\n", `5 first6 second`, g.String(), err)
+	}
+}
+
+func Test_FrameWriterMaxFrameSize(t *testing.T) {
+	const msg = `<1>1 - - - - - [a x="1"][b y="2"] msg`
+
+	cases := [...]struct {
+		in     string
+		max    int
+		cut    bool
+		marker string
+		nt     bool
+		exp    string
+		err    error
+	}{
+		{`hello world`, 11, false, ``, false, `11 hello world`, nil},
+		{`hello world`, 5, false, ``, false, ``, ErrFrameExceeded},
+		{`hello world`, 8, true, `...`, false, `8 hello...`, nil},
+		{`hello world`, 3, true, `[truncated]`, false, `3 hel`, nil},
+		{`hello world`, 11, true, `[truncated]`, false, `11 hello world`, nil},
+		{msg, 20, true, `[message truncated by relay]`, false, `16 <1>1 - - - - - -`, nil},
+		{`héllo`, 2, true, ``, false, `1 h`, nil},
+		{`héllo`, 3, true, ``, false, `3 hé`, nil},
+		{msg, 30, true, `~`, false, `26 <1>1 - - - - - [a x="1"] ~`, nil},
+		{msg, 26, true, ``, false, `24 <1>1 - - - - - [a x="1"]`, nil},
+		{msg, 20, true, ``, false, `16 <1>1 - - - - - -`, nil},
+		{msg, 36, true, ``, false, `36 <1>1 - - - - - [a x="1"][b y="2"] ms`, nil},
+		{`<1>1 - - - - - [a x="]\"]"] msg`, 27, true, ``, false, `16 <1>1 - - - - - -`, nil},
+		{"a\nbcdef", 6, true, ``, true, "a#012b\n", nil},
+		{"a\nbcdef", 4, true, ``, true, "a\n", nil},
+	}
+	for _, c := range cases {
+		b := new(bytes.Buffer)
+		w := NewFrameWriter(b, make([]byte, 64))
+		if c.nt {
+			w = NewNonTransparentWriter(b, make([]byte, 64), TrailerLF, TrailerEscape)
+		}
+		if w.SetMaxFrameSize(c.max); c.cut {
+			w.TruncateExceeded([]byte(c.marker))
+		}
+		_, err := w.Write([]byte(c.in))
+		w.Flush()
+
+		if out := b.String(); out != c.exp || err != c.err {
+			t.Errorf("\n\tfor: %q, max frame size = %d\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.max, c.exp, c.err, out, err)
+		} else if i := strings.IndexByte(out, ' '); !c.nt && len(out)-i-1 > c.max {
+			t.Errorf("\n\tfor: %q, max frame size = %d\n\texp: %d bytes at most\n\tgot: %q\n", c.in, c.max, c.max, out)
+		}
+	}
+}
+
+func Test_NonTransparentWriter(t *testing.T) {
+	cases := [...]struct {
+		in      []string
+		trailer Trailer
+		policy  TrailerPolicy
+		bufSize int
+		exp     string
+		err     error
+	}{
+		{[]string{"a\nb", ``, `c`}, TrailerLF, TrailerEscape, 128, "a#012b\nc\n", nil},
+		{[]string{"a\nb", ``, `c`}, TrailerLF, TrailerEscape, 0, "a#012b\nc\n", nil},
+		{[]string{"a\nb", ``, `c`}, TrailerLF, TrailerEscape, 4, "a#012b\nc\n", nil},
+		{[]string{"\n\n", `c`}, TrailerLF, TrailerEscape, 3, "#012#012\nc\n", nil},
+		{[]string{"a\nb", `c`}, TrailerLF, TrailerReplace, 128, "a b\nc\n", nil},
+		{[]string{"a\nb", `c`}, TrailerLF, TrailerReject, 128, "c\n", ErrTrailerInFrame},
+		{[]string{"a\x00b", `c`}, TrailerNUL, TrailerEscape, 128, "a#000b\x00c\x00", nil},
+		{[]string{"a\nb", `c`}, TrailerNUL, TrailerReject, 128, "a\nb\x00c\x00", nil},
+		{[]string{"a\r\nb", `c`}, TrailerCRLF, TrailerEscape, 128, "a\r#012b\r\nc\r\n", nil},
+	}
+	for _, c := range cases {
+		var err error
+
+		b := new(bytes.Buffer)
+		w := NewNonTransparentWriter(b, make([]byte, c.bufSize), c.trailer, c.policy)
+
+		for _, frame := range c.in {
+			if _, e := w.Write([]byte(frame)); e != nil {
+				err = e
+			}
+		}
+		w.Flush()
+
+		if out := b.String(); out != c.exp || err != c.err {
+			t.Errorf("\n\tfor: %q, %v, buffer size = %d\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.trailer, c.bufSize, c.exp, c.err, out, err)
+		}
+	}
+}
+
 func Test_FrameScanner(t *testing.T) {
 	cases := [...]struct {
 		in           []byte
@@ -80,6 +426,612 @@ func Test_FrameScanner(t *testing.T) {
 	}
 }
 
+func Test_NonTransparentScanner(t *testing.T) {
+	cases := [...]struct {
+		in           []byte
+		exp          []string
+		err          error
+		trailer      Trailer
+		unterminated bool
+		bufSize      int
+		maxFrameSize int
+	}{
+		{[]byte("first\nsecond\n"), []string{`first`, `second`}, nil, TrailerLF, false, 8, 16},
+		{[]byte("first\nsecond"), []string{`first`, `second`}, nil, TrailerLF, true, 8, 16},
+		{[]byte("\n\nfirst\n\n\nsecond\n\n"), []string{`first`, `second`}, nil, TrailerLF, false, 8, 16},
+		{[]byte("first\x00second\x00"), []string{`first`, `second`}, nil, TrailerNUL, false, 8, 16},
+		{[]byte("first\r\nsec\nond\r\n\r\nthird"), []string{`first`, "sec\nond", `third`}, nil, TrailerCRLF, true, 8, 16},
+		{[]byte("first\nsecond1234567890\nthird\n"), []string{`first`, `second12`, `third`}, nil, TrailerLF, false, 8, 32},
+		{[]byte("first\nsecond1234567890123456789\nthird\n"), []string{`first`, `second12`, `third`}, nil, TrailerLF, false, 8, 32},
+		{[]byte("first\r\nsecond1\r\nthird\r\n"), []string{`first`, `second1`, `third`}, nil, TrailerCRLF, false, 8, 32},
+		{[]byte("first\r\nsecond12\r\nthird\r\n"), []string{`first`, `second1`, `third`}, nil, TrailerCRLF, false, 8, 32},
+		{[]byte("first\nsecond\n"), []string{`first`}, ErrFrameExceeded, TrailerLF, false, 8, 5},
+		{[]byte("first\nsecond"), []string{`first`}, ErrFrameExceeded, TrailerLF, false, 8, 5},
+		{[]byte("first\nsecond1234567890\n"), []string{`first`, `second12`}, ErrFrameExceeded, TrailerLF, false, 8, 12},
+	}
+	b := new(bytes.Buffer)
+
+	for _, c := range cases {
+		b.Reset()
+		b.Write(c.in)
+
+		f := NewNonTransparentScanner(b, make([]byte, c.bufSize), c.trailer, c.maxFrameSize)
+		out, unterminated := []string{}, false
+
+		for f.Next() {
+			out = append(out, string(f.Bytes()))
+			unterminated = f.Unterminated()
+		}
+		if err := f.Err(); err != c.err || !reflect.DeepEqual(c.exp, out) || c.unterminated != unterminated {
+			t.Errorf("\n\tfor: %q, %s\n\texp: %q, %v, %t\n\tgot: %q, %v, %t\n",
+				c.in, c.trailer, c.exp, c.err, c.unterminated, out, err, unterminated)
+		}
+	}
+}
+
+func Test_AutoFrameScanner(t *testing.T) {
+	cases := [...]struct {
+		in           []byte
+		exp          []string
+		err          error
+		trailer      Trailer
+		bufSize      int
+		maxFrameSize int
+	}{
+		{
+			[]byte("5 first<1>second\n6 second<2>third"),
+			[]string{`octet-counting:first`, `non-transparent:<1>second`, `octet-counting:second`, `non-transparent:<2>third`},
+			nil, TrailerLF, 16, 32,
+		},
+		{
+			[]byte("5 first\n\n<1>second\n\n5 third\n"),
+			[]string{`octet-counting:first`, `non-transparent:<1>second`, `octet-counting:third`},
+			nil, TrailerLF, 16, 32,
+		},
+		{
+			[]byte("<1>first\r\n6 second\r\n<2>third\r\n"),
+			[]string{`non-transparent:<1>first`, `octet-counting:second`, `non-transparent:<2>third`},
+			nil, TrailerCRLF, 16, 32,
+		},
+		{
+			[]byte("<1>first\x006 second<2>third\x00"),
+			[]string{`non-transparent:<1>first`, `octet-counting:second`, `non-transparent:<2>third`},
+			nil, TrailerNUL, 16, 32,
+		},
+		{
+			[]byte("<1>first1234567890\n5 third"),
+			[]string{`non-transparent:<1>first`, `octet-counting:third`},
+			nil, TrailerLF, 8, 32,
+		},
+		{
+			[]byte("<1>first\n05 second"),
+			[]string{`non-transparent:<1>first`, `non-transparent:05 second`},
+			nil, TrailerLF, 16, 32,
+		},
+		{
+			[]byte("5 first2003-10-11 host: second\n6 fourth"),
+			[]string{`octet-counting:first`, `non-transparent:2003-10-11 host: second`, `octet-counting:fourth`},
+			nil, TrailerLF, 32, 32,
+		},
+		{
+			[]byte("1234567890 first\n"),
+			[]string{`non-transparent:1234567890 first`},
+			nil, TrailerLF, 32, 32,
+		},
+		{
+			[]byte("5 first<1>second1234567890\n"),
+			[]string{`octet-counting:first`},
+			ErrFrameExceeded, TrailerLF, 16, 12,
+		},
+	}
+	b := new(bytes.Buffer)
+
+	for _, c := range cases {
+		b.Reset()
+		b.Write(c.in)
+
+		f := NewAutoFrameScanner(b, make([]byte, c.bufSize), c.maxFrameSize, c.trailer)
+		out := []string{}
+
+		for f.Next() {
+			out = append(out, f.Framing().String()+`:`+string(f.Bytes()))
+		}
+		if err := f.Err(); err != c.err || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q, %s\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.trailer, c.exp, c.err, out, err)
+		}
+		if c.bufSize < 16 {
+			continue
+		}
+		f = NewAutoFrameScanner(iotest.OneByteReader(bytes.NewReader(c.in)), make([]byte, c.bufSize), c.maxFrameSize, c.trailer)
+		out = []string{}
+
+		for f.Next() {
+			out = append(out, f.Framing().String()+`:`+string(f.Bytes()))
+		}
+		if err := f.Err(); err != c.err || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q, %s, one byte at a time\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.trailer, c.exp, c.err, out, err)
+		}
+	}
+}
+
+func Test_FrameScannerResync(t *testing.T) {
+	cases := [...]struct {
+		in           []byte
+		exp          []string
+		skipped      []int
+		bufSize      int
+		maxFrameSize int
+	}{
+		{[]byte(`6 <1>one6 <2>two`), []string{`<1>one`, `<2>two`}, []int{}, 16, 16},
+		{[]byte(`6 <1>one06 <2>two6 <3>thr`), []string{`<1>one`, `<2>two`, `<3>thr`}, []int{1}, 16, 16},
+		{[]byte(`6 <1>onexx 12 <3>zz6 <4>fou`), []string{`<1>one`, `<4>fou`}, []int{11}, 16, 8},
+		{[]byte(`6 <1>one12x6 <2>two`), []string{`<1>one`, `<2>two`}, []int{3}, 16, 16},
+		{[]byte(`6 <1>onegarbage-garbage6 <2>two`), []string{`<1>one`, `<2>two`}, []int{15}, 8, 16},
+		{[]byte(`6 <1>oneabcdef6 <2>two`), []string{`<1>one`, `<2>two`}, []int{6}, 8, 16},
+		{[]byte(`6 <1>onex1234567890 <2>two`), []string{`<1>one`}, []int{18}, 8, 0},
+		{[]byte(`6 <1>onexyz`), []string{`<1>one`}, []int{3}, 8, 16},
+	}
+	b := new(bytes.Buffer)
+
+	for _, c := range cases {
+		b.Reset()
+		b.Write(c.in)
+
+		f := NewFrameScanner(b, make([]byte, c.bufSize), c.maxFrameSize)
+		out, skipped := []string{}, []int{}
+		f.Resync(func(n int) { skipped = append(skipped, n) })
+
+		for f.Next() {
+			out = append(out, string(f.Bytes()))
+		}
+		total := int64(0)
+		for _, n := range skipped {
+			total += int64(n)
+		}
+		if err := f.Err(); err != nil || !reflect.DeepEqual(c.exp, out) || !reflect.DeepEqual(c.skipped, skipped) || total != f.Skipped() {
+			t.Errorf("\n\tfor: %q\n\texp: %q, %v\n\tgot: %q, %v, %d, %v\n", c.in, c.exp, c.skipped, out, skipped, f.Skipped(), err)
+		}
+	}
+}
+
+func Test_FrameScannerTruncated(t *testing.T) {
+	cases := [...]struct {
+		in           []byte
+		exp          []string
+		auto         bool
+		grow         bool
+		maxFrameSize int
+	}{
+		{[]byte(`5 first16 second12345678905 third`), []string{`first:5:false`, `second12:16:true`, `third:5:false`}, false, false, 32},
+		{[]byte(`5 first16 second12345678905 third`), []string{`first:5:false`, `second1234567890:16:false`, `third:5:false`}, false, true, 32},
+		{[]byte(`5 first16 second12345678905 third`), []string{`first:5:false`, `second12:16:true`, `third:5:false`}, false, true, 0},
+		{[]byte(`5 first9 sec`), []string{`first:5:false`, `sec:9:true`}, false, false, 32},
+		{[]byte("<1>first1234567890\n5 third"), []string{`<1>first:-1:true`, `third:5:false`}, true, false, 32},
+		{[]byte("<1>first1234567890\n5 third"), []string{`<1>first1234567890:18:false`, `third:5:false`}, true, true, 32},
+		{[]byte("<1>first1234567890"), []string{`<1>first1234567890:18:false`}, true, true, 32},
+	}
+	b := new(bytes.Buffer)
+
+	for _, c := range cases {
+		b.Reset()
+		b.Write(c.in)
+
+		f := NewFrameScanner(b, make([]byte, 8), c.maxFrameSize)
+		if c.auto {
+			f = NewAutoFrameScanner(b, make([]byte, 8), c.maxFrameSize, TrailerLF)
+		}
+		f.Grow(c.grow)
+		out := []string{}
+
+		for f.Next() {
+			out = append(out, string(f.Bytes())+`:`+strconv.Itoa(f.Len())+`:`+strconv.FormatBool(f.Truncated()))
+		}
+		if err := f.Err(); err != nil || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q, grow = %t\n\texp: %q\n\tgot: %q, %v\n", c.in, c.grow, c.exp, out, err)
+		}
+	}
+}
+
+func Test_FrameScannerNextReader(t *testing.T) {
+	cases := [...]struct {
+		in   []byte
+		read []int
+		exp  []string
+		err  error
+	}{
+		{[]byte(`5 first16 second12345678905 third`), []int{-1, -1, -1}, []string{`first:5`, `second1234567890:16`, `third:5`}, io.EOF},
+		{[]byte(`5 first16 second12345678905 third`), []int{2, 3, -1}, []string{`fi:5`, `sec:16`, `third:5`}, io.EOF},
+		{[]byte(`5 first16 second12345678905 third`), []int{0, 0, 0}, []string{`:5`, `:16`, `:5`}, io.EOF},
+		{[]byte(`5 first16 second1234`), []int{-1, -1}, []string{`first:5`, `second1234:16`}, io.ErrUnexpectedEOF},
+		{[]byte(`5 first16 second1234`), []int{-1, 0}, []string{`first:5`, `:16`}, io.EOF},
+		{[]byte(`10 abc`), []int{-1}, []string{`abc:10`}, io.ErrUnexpectedEOF},
+		{[]byte(`5 first12`), []int{-1}, []string{`first:5`}, io.ErrUnexpectedEOF},
+		{[]byte(`5 first05 third`), []int{1}, []string{`f:5`}, ErrFrame},
+	}
+	for _, c := range cases {
+		f := NewFrameScanner(bytes.NewReader(c.in), make([]byte, 8), 0)
+		out, err := []string{}, error(nil)
+
+		for _, n := range c.read {
+			var (
+				r    io.Reader
+				size int
+				b    []byte
+			)
+			if r, size, err = f.NextReader(); err != nil {
+				break
+			}
+			if n < 0 {
+				b, _ = io.ReadAll(r)
+			} else {
+				b = make([]byte, n)
+				io.ReadFull(r, b)
+			}
+			out = append(out, string(b)+`:`+strconv.Itoa(size))
+		}
+		if err == nil {
+			_, _, err = f.NextReader()
+		}
+		if err != c.err || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q, %v\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.read, c.exp, c.err, out, err)
+		}
+		if exp := c.err; f.Err() != exp && (exp != io.EOF || f.Err() != nil) {
+			t.Errorf("\n\tfor: %q, %v, Err\n\texp: %v\n\tgot: %v\n", c.in, c.read, exp, f.Err())
+		}
+	}
+	f := NewFrameScanner(bytes.NewReader([]byte(`5 first16 second12345678905 third`)), make([]byte, 8), 0)
+	r, _, _ := f.NextReader()
+	io.ReadFull(r, make([]byte, 2))
+
+	if !f.Next() || string(f.Bytes()) != `second12` || !f.Next() || string(f.Bytes()) != `third` {
+		t.Errorf("\n\tfor: Next after NextReader\n\texp: %q\n\tgot: %q\n", `third`, f.Bytes())
+	}
+}
+
+func Test_FrameScannerSkipExceeded(t *testing.T) {
+	cases := [...]struct {
+		in       []byte
+		exp      []string
+		exceeded []int
+		auto     bool
+		bufSize  int
+	}{
+		{[]byte(`5 first17 second1234567890a5 third`), []string{`first`, `third`}, []int{17}, false, 8},
+		{[]byte(`5 first17 second1234567890a5 third`), []string{`first`, `third`}, []int{17}, false, 32},
+		{[]byte(`17 second1234567890a17 second1234567890a`), []string{}, []int{17, 17}, false, 8},
+		{[]byte(`5 first17 second1234`), []string{`first`}, []int{17}, false, 8},
+		{[]byte("5 first<1>second1234567890\n<2>third\n"), []string{`first`, `<1>secon`, `<2>third`}, []int{19}, true, 8},
+		{[]byte("5 first<1>second1234567890\n<2>third\n"), []string{`first`, `<2>third`}, []int{19}, true, 32},
+	}
+	for _, c := range cases {
+		f := NewFrameScanner(bytes.NewReader(c.in), make([]byte, c.bufSize), 16)
+		if c.auto {
+			f = NewAutoFrameScanner(bytes.NewReader(c.in), make([]byte, c.bufSize), 16, TrailerLF)
+		}
+		out, exceeded := []string{}, []int{}
+		f.SkipExceeded(func(n int) { exceeded = append(exceeded, n) })
+
+		for f.Next() {
+			out = append(out, string(f.Bytes()))
+		}
+		if err := f.Err(); err != nil || !reflect.DeepEqual(c.exp, out) || !reflect.DeepEqual(c.exceeded, exceeded) {
+			t.Errorf("\n\tfor: %q, buffer size = %d\n\texp: %q, %v\n\tgot: %q, %v, %v\n", c.in, c.bufSize, c.exp, c.exceeded, out, exceeded, err)
+		}
+		if c.auto {
+			continue
+		}
+		f = NewFrameScanner(bytes.NewReader(c.in), make([]byte, c.bufSize), 16)
+		out, exceeded = []string{}, []int{}
+		f.SkipExceeded(func(n int) { exceeded = append(exceeded, n) })
+
+		r, _, err := f.NextReader()
+
+		for ; err == nil; r, _, err = f.NextReader() {
+			b, _ := io.ReadAll(r)
+			out = append(out, string(b))
+		}
+		if err != io.EOF || !reflect.DeepEqual(c.exp, out) || !reflect.DeepEqual(c.exceeded, exceeded) {
+			t.Errorf("\n\tfor: %q, NextReader, buffer size = %d\n\texp: %q, %v\n\tgot: %q, %v, %v\n", c.in, c.bufSize, c.exp, c.exceeded, out, exceeded, err)
+		}
+	}
+}
+
+func Test_FrameScannerTimeouts(t *testing.T) {
+	const (
+		short = 30 * time.Millisecond
+		long  = 5 * time.Second
+	)
+	cases := [...]struct {
+		in          string
+		auto        bool
+		idle, frame time.Duration
+		exp         []string
+		err         error
+	}{
+		{``, false, short, long, []string{}, ErrIdleTimeout},
+		{`5 first6 second`, false, short, long, []string{`first`, `second`}, ErrIdleTimeout},
+		{`5 first6 sec`, false, long, short, []string{`first`}, ErrFrameTimeout},
+		{`5 first16`, false, long, short, []string{`first`}, ErrFrameTimeout},
+		{"<1>first\n<2>sec", true, long, short, []string{`<1>first`}, ErrFrameTimeout},
+		{"<1>first\n\n", true, short, long, []string{`<1>first`}, ErrIdleTimeout},
+	}
+	for _, c := range cases {
+		r, w := net.Pipe()
+		go w.Write([]byte(c.in))
+
+		f := NewFrameScanner(r, make([]byte, 8), 0)
+		if c.auto {
+			f = NewAutoFrameScanner(r, make([]byte, 8), 0, TrailerLF)
+		}
+		f.SetTimeouts(c.idle, c.frame)
+		out := []string{}
+
+		for f.Next() {
+			out = append(out, string(f.Bytes()))
+		}
+		if err := f.Err(); err != c.err || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.exp, c.err, out, err)
+		}
+		r.Close()
+		w.Close()
+	}
+}
+
+func Test_FrameScannerNextContext(t *testing.T) {
+	r, w := net.Pipe()
+	defer w.Close()
+	defer r.Close()
+	go w.Write([]byte(`5 first6 sec`))
+
+	f := NewFrameScanner(r, make([]byte, 8), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if !f.NextContext(ctx) || string(f.Bytes()) != `first` {
+		t.Errorf("\n\tfor: NextContext\n\texp: %q\n\tgot: %q, %v\n", `first`, f.Bytes(), f.Err())
+	}
+	time.AfterFunc(30*time.Millisecond, cancel)
+
+	if f.NextContext(ctx) || f.Err() != context.Canceled {
+		t.Errorf("\n\tfor: NextContext, canceled\n\texp: %v\n\tgot: %q, %v\n", context.Canceled, f.Bytes(), f.Err())
+	}
+	f.Reset(bytes.NewReader([]byte(`5 first`)))
+
+	if f.NextContext(ctx) || f.Err() != context.Canceled {
+		t.Errorf("\n\tfor: NextContext, done\n\texp: %v\n\tgot: %q, %v\n", context.Canceled, f.Bytes(), f.Err())
+	}
+	f.Reset(bytes.NewReader([]byte(`5 first`)))
+
+	if !f.NextContext(context.Background()) || string(f.Bytes()) != `first` {
+		t.Errorf("\n\tfor: NextContext, background\n\texp: %q\n\tgot: %q, %v\n", `first`, f.Bytes(), f.Err())
+	}
+}
+
+func Test_FrameScannerPool(t *testing.T) {
+	cases := [...]struct {
+		in   []byte
+		auto bool
+	}{
+		{[]byte(`5 first6 second`), false},
+		{[]byte(`5 first17 second1234567890a5 third`), false},
+		{[]byte(`20 second1234567890abc4 four`), false},
+		{[]byte(`19 second1234567890abc4 four`), false},
+		{[]byte("<1>first\n5 first<2>second1234567890abc\n<3>third"), true},
+	}
+	for _, c := range cases {
+		var exp, out []string
+
+		p := NewBufferPool(16, 64)
+		f := NewFrameScanner(bytes.NewReader(c.in), make([]byte, 16), 0)
+		g := NewFrameScanner(iotest.HalfReader(bytes.NewReader(c.in)), make([]byte, 4), 0)
+		if c.auto {
+			f = NewAutoFrameScanner(bytes.NewReader(c.in), make([]byte, 16), 0, TrailerLF)
+			g = NewAutoFrameScanner(iotest.HalfReader(bytes.NewReader(c.in)), make([]byte, 4), 0, TrailerLF)
+		}
+		g.SetPool(p)
+
+		for f.Next() {
+			exp = append(exp, string(f.Bytes()))
+		}
+		expErr := f.Err()
+
+		for g.Next() {
+			if out = append(out, string(g.Bytes())); p.InUse() > 1 {
+				t.Errorf("\n\tfor: %q\n\texp: 1 buffer in use\n\tgot: %d\n", c.in, p.InUse())
+			}
+		}
+		if err := g.Err(); err != expErr || !reflect.DeepEqual(exp, out) || p.InUse() != 0 {
+			t.Errorf("\n\tfor: %q\n\texp: %q\n\tgot: %q, %d buffers in use, %v\n", c.in, exp, out, p.InUse(), err)
+		}
+	}
+}
+
+func Test_BufferPoolBudget(t *testing.T) {
+	p := NewBufferPool(16, 20)
+	r, w := net.Pipe()
+	defer r.Close()
+	defer w.Close()
+
+	f := NewFrameScanner(r, make([]byte, 8), 0)
+	f.SetPool(p)
+	next := make(chan bool)
+	go func() { next <- f.Next() }()
+	w.Write([]byte(`9 <1>`))
+
+	for p.InUse() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	g := NewFrameScanner(bytes.NewReader([]byte(`5 first`)), make([]byte, 8), 0)
+	g.SetPool(p)
+
+	if !g.Next() || string(g.Bytes()) != `first` {
+		t.Errorf("\n\tfor: frame that fits into the buffer\n\texp: %q\n\tgot: %q, %v\n", `first`, g.Bytes(), g.Err())
+	}
+	g.Reset(bytes.NewReader([]byte(`9 <2>second`)))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	if g.NextContext(ctx) || g.Err() != context.DeadlineExceeded {
+		t.Errorf("\n\tfor: budget spent\n\texp: %v\n\tgot: %q, %v\n", context.DeadlineExceeded, g.Bytes(), g.Err())
+	}
+	w.Write([]byte(`abcdef`))
+
+	if !<-next || string(f.Bytes()) != `<1>abcdef` {
+		t.Errorf("\n\tfor: frame read into the pool buffer\n\texp: %q\n\tgot: %q, %v\n", `<1>abcdef`, f.Bytes(), f.Err())
+	}
+	w.Close()
+
+	if f.Next() || p.InUse() != 0 {
+		t.Errorf("\n\tfor: end of stream\n\texp: 0 buffers in use\n\tgot: %d, %v\n", p.InUse(), f.Err())
+	}
+	g.Reset(bytes.NewReader([]byte(`9 <2>second`)))
+
+	if !g.Next() || string(g.Bytes()) != `<2>second` {
+		t.Errorf("\n\tfor: budget released\n\texp: %q\n\tgot: %q, %v\n", `<2>second`, g.Bytes(), g.Err())
+	}
+}
+
+type frameHook []string
+
+func (h *frameHook) FrameRead(size, length int) {
+	*h = append(*h, fmt.Sprintf("read %d/%d", size, length))
+}
+
+func (h *frameHook) FrameSkipped(n int, reason error) {
+	*h = append(*h, fmt.Sprintf("skipped %d: %v", n, reason))
+}
+
+func (h *frameHook) FrameError(err error) {
+	*h = append(*h, fmt.Sprintf("error: %v", err))
+}
+
+func Test_FrameScannerStats(t *testing.T) {
+	cases := [...]struct {
+		in      []byte
+		auto    bool
+		bufSize int
+		exp     FrameStats
+		events  frameHook
+	}{
+		{[]byte(`5 first6 second`), false, 16,
+			FrameStats{Frames: 2, Bytes: 15, MaxFrame: 6},
+			frameHook{`read 5/5`, `read 6/6`}},
+		{[]byte(`12 <1>abcdefghi5 <2>ab`), false, 8,
+			FrameStats{Frames: 2, Bytes: 22, Truncated: 1, Dropped: 4, MaxFrame: 12},
+			frameHook{`read 8/12`, `read 5/5`}},
+		{[]byte("<1>abcdefghijk\n<2>x\n"), true, 8,
+			FrameStats{Frames: 2, Bytes: 20, Truncated: 1, Dropped: 6, MaxFrame: 14},
+			frameHook{`read 8/-1`, `read 4/4`}},
+		{[]byte(`5 firstxx6 <1>sec`), false, 16,
+			FrameStats{Frames: 2, Bytes: 17, Resyncs: 1, Skipped: 2, MaxFrame: 6},
+			frameHook{`read 5/5`, `skipped 2: invalid frame`, `read 6/6`}},
+		{[]byte(`5 first17 second1234567890a5 third`), false, 32,
+			FrameStats{Frames: 2, Bytes: 34, Exceeded: 1, MaxFrame: 5},
+			frameHook{`read 5/5`, `skipped 17: frame size exceeded`, `read 5/5`}},
+		{[]byte(`5 first x`), false, 16,
+			FrameStats{Frames: 1, Bytes: 9, Errors: 1, MaxFrame: 5},
+			frameHook{`read 5/5`, `error: invalid frame`}},
+	}
+	for _, c := range cases {
+		var h frameHook
+
+		f := NewFrameScanner(bytes.NewReader(c.in), make([]byte, c.bufSize), 16)
+		if c.auto {
+			f = NewAutoFrameScanner(bytes.NewReader(c.in), make([]byte, c.bufSize), 16, TrailerLF)
+		}
+		f.SetHook(&h)
+		f.SkipExceeded(nil)
+		if c.exp.Resyncs > 0 {
+			f.Resync(nil)
+		}
+		for f.Next() {
+		}
+		f.Next()
+
+		if stats := f.Stats(); stats != c.exp || !reflect.DeepEqual(c.events, h) {
+			t.Errorf("\n\tfor: %q\n\texp: %+v, %q\n\tgot: %+v, %q\n", c.in, c.exp, c.events, stats, h)
+		}
+	}
+}
+
+var frameHeaderCases = [...]struct {
+	in  []byte
+	exp []string
+	err error
+}{
+	{[]byte(`0 `), []string{}, ErrFrame},
+	{[]byte(`0 5 first`), []string{}, ErrFrame},
+	{[]byte(`00 first`), []string{}, ErrFrame},
+	{[]byte(`05 first`), []string{}, ErrFrame},
+	{[]byte(`5 first0 `), []string{`first`}, ErrFrame},
+	{[]byte(` 5 first`), []string{}, ErrFrame},
+	{[]byte(`-5 first`), []string{}, ErrFrame},
+	{[]byte(`+5 first`), []string{}, ErrFrame},
+	{[]byte(`5first`), []string{}, ErrFrame},
+	{[]byte(`5  first`), []string{` firs`}, ErrFrame},
+	{[]byte(`5`), []string{}, nil},
+	{[]byte(`5 `), []string{}, nil},
+	{[]byte(`5 fir`), []string{`fir`}, nil},
+	{[]byte(`999999999 first`), []string{`first`}, nil},
+	{[]byte(`1000000000 first`), []string{}, ErrFrameLength},
+	{[]byte(`9223372036854775807 first`), []string{}, ErrFrameLength},
+	{[]byte(`9223372036854775808 first`), []string{}, ErrFrameLength},
+	{[]byte(`99999999999999999999999999999999 first`), []string{}, ErrFrameLength},
+	{[]byte(`5 first000000000000000000000000000000`), []string{`first`}, ErrFrame},
+}
+
+func Test_FrameScannerHeader(t *testing.T) {
+	for _, c := range frameHeaderCases {
+		f := NewFrameScanner(bytes.NewReader(c.in), make([]byte, 8), 0)
+		out := []string{}
+
+		for f.Next() {
+			out = append(out, string(f.Bytes()))
+		}
+		if err := f.Err(); err != c.err || !reflect.DeepEqual(c.exp, out) {
+			t.Errorf("\n\tfor: %q\n\texp: %q, %v\n\tgot: %q, %v\n", c.in, c.exp, c.err, out, err)
+		}
+	}
+}
+
+func FuzzFrameScanner(f *testing.F) {
+	for _, c := range frameHeaderCases {
+		f.Add(c.in, uint8(8))
+	}
+	f.Add([]byte("5 first<1>second\n6 second"), uint8(4))
+	f.Add([]byte("6 <1>onexx 12 <3>zz6 <4>fou"), uint8(16))
+
+	f.Fuzz(func(t *testing.T, in []byte, bufSize uint8) {
+		size := int(bufSize%64) + 2
+		scanners := [...]*FrameScanner{
+			NewFrameScanner(bytes.NewReader(in), make([]byte, size), 0),
+			NewFrameScanner(bytes.NewReader(in), make([]byte, size), 32),
+			NewAutoFrameScanner(bytes.NewReader(in), make([]byte, size), 32, TrailerLF),
+		}
+		scanners[1].Resync(nil)
+		scanners[2].SkipExceeded(nil)
+
+		for _, s := range scanners {
+			for s.Next() {
+				if n := len(s.Bytes()); n == 0 || n > size {
+					t.Fatalf("for: %q, buffer size = %d: frame length = %d", in, size, n)
+				}
+				if n := s.Len(); n > 999999999 {
+					t.Fatalf("for: %q, buffer size = %d: MSG-LEN = %d", in, size, n)
+				}
+			}
+			switch err := s.Err(); err {
+			case nil, ErrFrame, ErrFrameExceeded, ErrFrameLength:
+			default:
+				t.Fatalf("for: %q, buffer size = %d: unexpected error %v", in, size, err)
+			}
+			if s.Skipped() < 0 || s.Skipped() > int64(len(in)) {
+				t.Fatalf("for: %q, buffer size = %d: skipped = %d", in, size, s.Skipped())
+			}
+		}
+	})
+}
+
 func appendFrame(r, p []byte) []byte {
 	if len(p) == 0 {
 		return r
@@ -0,0 +1,257 @@
+package syslogp
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// Message is a syslog message. Its byte fields refer to
+// the data it was parsed from, a nil one is NILVALUE or missing.
+type Message struct {
+	Priority  Priority
+	Version   Version // 0 for an RFC 3164 message
+	Timestamp time.Time
+	Hostname  []byte
+	AppName   []byte
+	ProcId    []byte
+	MsgId     []byte
+
+	// StructData is STRUCTURED-DATA as it was sent,
+	// to be scanned by ScanStructData when needed.
+	StructData []byte
+	Msg        []byte
+
+	// Addr is the address of the sender and Received is the time
+	// the message was received, set by the receivers, as is Cred,
+	// the credentials of a local sender, where known.
+	Addr     net.Addr
+	Received time.Time
+	Cred     *Cred
+}
+
+// Handler handles syslog messages. The message and the data it refers to
+// are valid only until HandleMessage returns. Receivers running more than
+// one worker call it concurrently.
+type Handler interface {
+	HandleMessage(m *Message)
+}
+
+// ErrorHandler is implemented by handlers that want to be told about data
+// that could not be parsed as a message, which is dropped otherwise, and,
+// with nil data, about the errors that end or refuse a connection.
+type ErrorHandler interface {
+	HandleError(data []byte, addr net.Addr, err error)
+}
+
+// HandlerFunc is a function used as a Handler.
+type HandlerFunc func(m *Message)
+
+func (h HandlerFunc) HandleMessage(m *Message) {
+	h(m)
+}
+
+// ParseMessage parses data as an RFC 5424 message if VERSION follows PRI,
+// and as an RFC 3164 one otherwise.
+func ParseMessage(data []byte, m *Message) error {
+	if len(data) > 0 && data[0] == '<' {
+		if i := bytes.IndexByte(data[:min(len(data), 5)], '>'); 1 < i && i+2 < len(data) && '1' <= data[i+1] && data[i+1] <= '9' {
+			if c := data[i+2]; c == ' ' || ('0' <= c && c <= '9') {
+				return ParseRFC5424(data, m)
+			}
+		}
+	}
+	return ParseRFC3164(data, m)
+}
+
+// ParseRFC5424 parses data as an RFC 5424 message. Trailing LF, CR and NUL
+// bytes, appended by some senders, are left out of MSG.
+func ParseRFC5424(data []byte, m *Message) (err error) {
+	var pos int
+
+	if m.Priority, err = ParsePriority(data, &pos); err != nil {
+		return
+	}
+	if m.Version, err = ParseVersion(data, &pos); err != nil {
+		return
+	}
+	if m.Timestamp, err = ParseTimestamp(data, &pos); err != nil {
+		return
+	}
+	if m.Hostname, err = ScanHostname(data, &pos); err != nil {
+		return
+	}
+	if m.AppName, err = ScanAppName(data, &pos); err != nil {
+		return
+	}
+	if m.ProcId, err = ScanProcId(data, &pos); err != nil {
+		return
+	}
+	if m.MsgId, err = ScanMsgId(data, &pos); err != nil {
+		return
+	}
+	m.StructData, m.Msg = nil, nil
+	data = trimTrailer(data)
+
+	if pos == len(data)-1 && data[pos] == '-' {
+		return nil
+	}
+	mark, s := pos, StructDataScanner{}
+
+	if err = s.Scan(data, &pos, nopIterator{}); err != nil {
+		if err != ErrStructData || pos < len(data) || data[len(data)-1] != ']' {
+			return
+		}
+		pos = 0
+		if err = s.Scan(append(data[mark:len(data):len(data)], ' '), &pos, nopIterator{}); err != nil {
+			return
+		}
+		m.StructData = data[mark:]
+		return nil
+	}
+	if data[mark] == '[' {
+		m.StructData = data[mark : pos-1]
+	}
+	if pos < len(data) {
+		m.Msg = data[pos:]
+	}
+	return nil
+}
+
+// ParseRFC3164 parses data as an RFC 3164 message, the way it is seen in
+// practice: PRI defaults to USER.NOTICE when missing, and the year of
+// TIMESTAMP, which has none, is the one of the time received or the current
+// time, unless that puts it in the future. HOSTNAME may be missing, as in
+// messages sent to /dev/log, and TAG is split into APP-NAME and PROCID,
+// "tag[pid]:". Without a valid TIMESTAMP, all that follows PRI is MSG.
+func ParseRFC3164(data []byte, m *Message) (err error) {
+	var pos int
+
+	m.Priority, m.Version, m.Timestamp = USER|NOTICE, 0, time.Time{}
+	m.Hostname, m.AppName, m.ProcId, m.MsgId = nil, nil, nil, nil
+	m.StructData, m.Msg = nil, nil
+
+	if len(data) > 0 && data[0] == '<' {
+		if m.Priority, err = ParsePriority(data, &pos); err != nil {
+			return
+		}
+	}
+	now := m.Received
+
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if data = trimTrailer(data); parseStamp(data, &pos, now, &m.Timestamp) {
+		if i := bytes.IndexByte(data[pos:], ' '); i > 0 {
+			if host := data[pos : pos+i]; isHostname(host) {
+				m.Hostname = host
+				pos += i + 1
+			}
+		}
+		pos = scanTag(data, pos, m)
+	}
+	if pos < len(data) {
+		m.Msg = data[pos:]
+	}
+	return nil
+}
+
+// parseStamp parses TIMESTAMP of an RFC 3164 message, "Mmm dd hh:mm:ss ",
+// in the local time zone.
+func parseStamp(data []byte, pos *int, now time.Time, t *time.Time) bool {
+	var v [4]int
+
+	if p := data[*pos:]; len(p) >= 16 && p[3] == ' ' && p[6] == ' ' && p[9] == ':' && p[12] == ':' && p[15] == ' ' {
+		month := bytes.Index([]byte(months), p[:3])
+
+		if month < 0 || month%3 != 0 {
+			return false
+		}
+		for i, j := range [...]int{4, 7, 10, 13} {
+			if c := p[j]; c == ' ' && j == 4 {
+				c = '0'
+			} else if '0' > c || c > '9' {
+				return false
+			} else {
+				v[i] = int(c-'0') * 10
+			}
+			if c := p[j+1]; '0' > c || c > '9' {
+				return false
+			} else {
+				v[i] += int(c - '0')
+			}
+		}
+		if v[0] < 1 || v[0] > 31 || v[1] > 23 || v[2] > 59 || v[3] > 60 {
+			return false
+		}
+		*t = time.Date(now.Year(), time.Month(month/3+1), v[0], v[1], v[2], v[3], 0, time.Local)
+
+		if t.Sub(now) > 7*24*time.Hour {
+			*t = t.AddDate(-1, 0, 0)
+		}
+		*pos += 16
+		return true
+	}
+	return false
+}
+
+// scanTag scans TAG of an RFC 3164 message at pos, "tag[pid]: " or "tag: ",
+// and returns the position of MSG, which is pos if there is no TAG.
+func scanTag(data []byte, pos int, m *Message) int {
+	i := pos
+
+	for i < len(data) && i-pos < 48 && data[i] > ' ' && data[i] <= '~' && data[i] != '[' && data[i] != ':' {
+		i++
+	}
+	if i == pos || i == len(data) {
+		return pos
+	}
+	tag, pid := data[pos:i], []byte(nil)
+
+	if data[i] == '[' {
+		j := bytes.IndexByte(data[i:], ']')
+		if j < 2 || i+j+1 == len(data) {
+			return pos
+		}
+		pid, i = data[i+1:i+j], i+j+1
+	}
+	if data[i] != ':' {
+		return pos
+	}
+	if m.AppName, m.ProcId = tag, pid; i+1 < len(data) && data[i+1] == ' ' {
+		return i + 2
+	}
+	return i + 1
+}
+
+// isHostname reports whether p is HOSTNAME rather than TAG of
+// an RFC 3164 message.
+func isHostname(p []byte) bool {
+	if len(p) > 255 || p[len(p)-1] == ':' {
+		return false
+	}
+	for _, c := range p {
+		if c < 33 || c > 126 || c == '[' || c == ']' {
+			return false
+		}
+	}
+	return true
+}
+
+func trimTrailer(data []byte) []byte {
+	for len(data) > 0 {
+		if c := data[len(data)-1]; c != '\n' && c != '\r' && c != 0 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+type nopIterator struct{}
+
+func (nopIterator) StructDataEach(id, param, value []byte, typ ValueType) error {
+	return nil
+}
+
+const months = `JanFebMarAprMayJunJulAugSepOctNovDec`
@@ -0,0 +1,107 @@
+package syslogp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func formatMessage(m *Message) string {
+	return fmt.Sprintf("%d %d %s %q %q %q %q %q %q",
+		m.Priority, m.Version, m.Timestamp.Format(time.RFC3339Nano),
+		m.Hostname, m.AppName, m.ProcId, m.MsgId, m.StructData, m.Msg,
+	)
+}
+
+func Test_ParseRFC5424(t *testing.T) {
+	cases := [...]struct {
+		in  string
+		exp string
+		err error
+	}{
+		{`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed for lonvick on /dev/pts/8`,
+			`34 1 2003-10-11T22:14:15.003Z "mymachine.example.com" "su" "" "ID47" "" "'su root' failed for lonvick on /dev/pts/8"`, nil},
+		{`<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.`,
+			`165 1 2003-08-24T05:14:15.000003-07:00 "192.0.2.1" "myproc" "8710" "" "" "%% It's time to make the do-nuts."`, nil},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`,
+			`165 1 2003-10-11T22:14:15.003Z "mymachine.example.com" "evntslog" "" "ID47" "[exampleSDID@32473 iut=\"3\" eventSource=\"Application\"]" "An application event"`, nil},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"][examplePriority@32473 class="high"]`,
+			`165 1 2003-10-11T22:14:15.003Z "mymachine.example.com" "evntslog" "" "ID47" "[exampleSDID@32473 iut=\"3\"][examplePriority@32473 class=\"high\"]" ""`, nil},
+		{"<14>1 - - - - - -\n", `14 1 0001-01-01T00:00:00Z "" "" "" "" "" ""`, nil},
+		{"<14>1 - - - - - - msg\x00", `14 1 0001-01-01T00:00:00Z "" "" "" "" "" "msg"`, nil},
+		{`<14>1 - - - - - [a`, ``, ErrStructData},
+		{`<14>1 - - - - -`, ``, ErrMsgId},
+		{`<14>1 - - - -`, ``, ErrProcId},
+		{`<14> - - - - - -`, ``, ErrVersion},
+		{`14>1 - - - - - -`, ``, ErrPriority},
+	}
+	for _, c := range cases {
+		var m Message
+
+		err := ParseRFC5424([]byte(c.in), &m)
+		if out := formatMessage(&m); err != c.err || (err == nil && out != c.exp) {
+			t.Errorf("\n\tfor: %q\n\texp: %s, %v\n\tgot: %s, %v\n", c.in, c.exp, c.err, out, err)
+		}
+	}
+}
+
+func Test_ParseRFC3164(t *testing.T) {
+	received := time.Date(2003, time.October, 12, 0, 0, 0, 0, time.Local)
+	stamp := func(year int) string {
+		return time.Date(year, time.October, 11, 22, 14, 15, 0, time.Local).Format(time.RFC3339Nano)
+	}
+	cases := [...]struct {
+		in       string
+		received time.Time
+		exp      string
+	}{
+		{`<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`, received,
+			`34 0 ` + stamp(2003) + ` "mymachine" "su" "" "" "" "'su root' failed for lonvick on /dev/pts/8"`},
+		{"<13>Oct 11 22:14:15 sshd[1234]: Accepted publickey\n", received,
+			`13 0 ` + stamp(2003) + ` "" "sshd" "1234" "" "" "Accepted publickey"`},
+		{`<13>Oct 11 22:14:15 host app[77]:msg`, received,
+			`13 0 ` + stamp(2003) + ` "host" "app" "77" "" "" "msg"`},
+		{`<13>Oct 11 22:14:15 host just a message`, received,
+			`13 0 ` + stamp(2003) + ` "host" "" "" "" "" "just a message"`},
+		{`<13>Oct 11 22:14:15 host`, received,
+			`13 0 ` + stamp(2003) + ` "" "" "" "" "" "host"`},
+		{`<13>Oct 11 22:14:15 host app[]: msg`, received,
+			`13 0 ` + stamp(2003) + ` "host" "" "" "" "" "app[]: msg"`},
+		{`<13>Oct 11 22:14:15 host app: msg`, time.Date(2004, time.January, 2, 0, 0, 0, 0, time.Local),
+			`13 0 ` + stamp(2003) + ` "host" "app" "" "" "" "msg"`},
+		{`<13>Oct 32 22:14:15 host app: msg`, received,
+			`13 0 0001-01-01T00:00:00Z "" "" "" "" "" "Oct 32 22:14:15 host app: msg"`},
+		{`use the BFG!`, received,
+			`13 0 0001-01-01T00:00:00Z "" "" "" "" "" "use the BFG!"`},
+	}
+	for _, c := range cases {
+		m := Message{Received: c.received}
+
+		err := ParseRFC3164([]byte(c.in), &m)
+		if out := formatMessage(&m); err != nil || out != c.exp {
+			t.Errorf("\n\tfor: %q\n\texp: %s\n\tgot: %s, %v\n", c.in, c.exp, out, err)
+		}
+	}
+}
+
+func Test_ParseMessage(t *testing.T) {
+	cases := [...]struct {
+		in  string
+		exp Version
+	}{
+		{`<14>1 - - - - - -`, 1},
+		{`<14>12 - - - - - -`, 12},
+		{`<14>Oct 11 22:14:15 host app: msg`, 0},
+		{`<14>1msg`, 0},
+		{`msg`, 0},
+		{`Oct 11 22:14:15 host app: a>1 b`, 0},
+		{`<14>Oct 11 22:14:15 host app: a>1 b`, 0},
+	}
+	for _, c := range cases {
+		var m Message
+
+		if err := ParseMessage([]byte(c.in), &m); err != nil || m.Version != c.exp {
+			t.Errorf("\n\tfor: %q\n\texp: version %d\n\tgot: %d, %v\n", c.in, c.exp, m.Version, err)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package syslogp
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"strconv"
+	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -15,7 +19,90 @@ type StructDataIterator interface {
 	StructDataEach(id, param, value []byte, typ ValueType) error
 }
 
-func ScanStructData(data []byte, pos *int, quotes bool, iter StructDataIterator) (err error) {
+// StructDataChecker is implemented by iterators that want to be told about
+// PARAM-VALUEs not strictly following RFC 5424. StructDataCheck is called
+// just before StructDataEach for such a value, with the number of escape
+// sequences other than \", \\ and \] in it, and whether it is valid UTF-8.
+type StructDataChecker interface {
+	StructDataCheck(id, param, value []byte, escapes int, validUTF8 bool) error
+}
+
+// StructDataScanner holds the settings used to scan STRUCTURED-DATA.
+// The zero value infers no value kinds, so that every PARAM-VALUE
+// is reported as a string.
+type StructDataScanner struct {
+	// Quotes keeps the enclosing quotes of string values.
+	Quotes bool
+
+	// Kinds is the set of value kinds to infer.
+	Kinds ValueKind
+
+	// ParamKinds, if not nil, is called with Kinds for every SD-PARAM
+	// and returns the set of value kinds to infer for its value.
+	ParamKinds func(id, param []byte, kinds ValueKind) ValueKind
+
+	// Limits bounds the section to resist hostile input.
+	Limits StructDataLimits
+
+	// Escapes selects the escape sequences interpreted in values.
+	Escapes EscapeMode
+}
+
+// EscapeMode selects the escape sequences interpreted in a PARAM-VALUE.
+type EscapeMode uint8
+
+const (
+	// EscapeRFC interprets \", \\ and \] only,
+	// any other backslash is taken literally.
+	EscapeRFC EscapeMode = iota
+
+	// EscapeC also interprets the common C escape sequences of lax senders,
+	// \n, \r, \t, \0, \a, \b, \f, \v and \'. A value containing them
+	// is handed over unescaped, as a string with no escapes left.
+	EscapeC
+)
+
+// StructDataLimits bounds the STRUCTURED-DATA accepted by the scanner.
+// A zero field takes its value from DefaultStructDataLimits,
+// a negative one means no limit.
+type StructDataLimits struct {
+	Elements int // SD-ELEMENTs in the section
+	Params   int // SD-PARAMs in an SD-ELEMENT
+	Size     int // bytes of the section, including the trailing SP
+	Value    int // bytes of an escaped PARAM-VALUE
+}
+
+var DefaultStructDataLimits = StructDataLimits{
+	Elements: 32,
+	Params:   64,
+	Size:     16 << 10,
+	Value:    4 << 10,
+}
+
+func (l StructDataLimits) resolve() StructDataLimits {
+	l.Elements = limit(l.Elements, DefaultStructDataLimits.Elements)
+	l.Params = limit(l.Params, DefaultStructDataLimits.Params)
+	l.Size = limit(l.Size, DefaultStructDataLimits.Size)
+	l.Value = limit(l.Value, DefaultStructDataLimits.Value)
+	return l
+}
+
+func limit(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	if v < 0 {
+		return int(^uint(0) >> 1)
+	}
+	return v
+}
+
+func ScanStructData(data []byte, pos *int, quotes bool, iter StructDataIterator) error {
+	s := StructDataScanner{Quotes: quotes, Kinds: DefaultKinds}
+	return s.Scan(data, pos, iter)
+}
+
+func (s *StructDataScanner) Scan(data []byte, pos *int, iter StructDataIterator) (err error) {
 	var (
 		c      byte
 		state  uint8
@@ -27,12 +114,23 @@ func ScanStructData(data []byte, pos *int, quotes bool, iter StructDataIterator)
 		vstate uint8
 		vlen   uint8
 		vtyp   ValueType
+		kinds  ValueKind
+		vbad   int
+		vesc   int
+		elems  int
+		params int
+		cut    bool
+		lim    = s.Limits.resolve()
 	)
 	edger, edgerOk := iter.(StructDataEdger)
+	checker, checkerOk := iter.(StructDataChecker)
 
 	if eof == 0 || eof <= *pos {
 		goto _err
 	}
+	if eof-*pos > lim.Size {
+		eof, cut = *pos+lim.Size, true
+	}
 	if len(data[*pos:]) > 1 && data[*pos] == '-' && data[*pos+1] == ' ' {
 		*pos += 2
 		if edgerOk {
@@ -48,6 +146,7 @@ _resume:
 	case 0:
 		if c == '[' {
 			state = 1
+			elems, params = 1, 0
 			if edgerOk {
 				if err = edger.StructDataBegin(); err != nil {
 					return
@@ -79,6 +178,9 @@ _resume:
 		goto _err
 	case 3:
 		if ' ' < c && c <= '~' && c != '"' && c != '=' {
+			if params++; params > lim.Params {
+				goto _limit
+			}
 			state = 4
 			goto _mark
 		}
@@ -87,6 +189,9 @@ _resume:
 		if c == '=' {
 			state = 5
 			param = data[mark:*pos]
+			if kinds = s.Kinds; s.ParamKinds != nil {
+				kinds = s.ParamKinds(id, param, kinds)
+			}
 			goto _next
 		}
 		if ' ' < c && c <= '~' && c != '"' && *pos-mark < 31 {
@@ -96,7 +201,7 @@ _resume:
 	case 5:
 		if c == '"' {
 			state = 6
-			vstate, vlen, vtyp = 1, 0, 0
+			vstate, vlen, vtyp, vbad, vesc = 1, 0, 0, 0, 0
 			goto _next
 		}
 		goto _err
@@ -108,6 +213,7 @@ _resume:
 			goto _process
 		case '\\':
 			state = 8
+			vstate = 0
 		default:
 			state = 7
 			valueType(&vstate, &vlen, c)
@@ -118,27 +224,29 @@ _resume:
 		case '"':
 			state = 9
 			value = data[mark:*pos]
-			switch vstate {
-			case 16, 17:
-				vtyp = Float
-			case 18:
-				vtyp = Int
-			case 19:
-				vtyp = Nil
-			case 20:
-				vtyp = False
-			case 21:
-				vtyp = True
-			default:
-				if quotes {
-					value = data[mark-1 : *pos+1]
+			if checkerOk {
+				if valid := utf8.Valid(value); vbad > 0 || !valid {
+					if err = checker.StructDataCheck(id, param, value, vbad, valid); err != nil {
+						return
+					}
 				}
 			}
+			if t := inferValue(value, vstate, vlen, kinds); t != String {
+				vtyp = t
+			} else if vesc > 0 {
+				value, vtyp = unescapeC(nil, value, s.Quotes), String
+			} else if s.Quotes {
+				value = data[mark-1 : *pos+1]
+			}
 			goto _process
 		case '\\':
 			state = 8
+			vstate = 0
 			goto _next
 		}
+		if *pos-mark >= lim.Value {
+			goto _limit
+		}
 		if vstate > 0 {
 			valueType(&vstate, &vlen, c)
 		}
@@ -146,6 +254,11 @@ _resume:
 	case 8:
 		if c == '"' || c == '\\' || c == ']' {
 			vtyp++
+		} else if vbad++; s.Escapes == EscapeC && isEscapeC(c) {
+			vesc++
+		}
+		if *pos-mark >= lim.Value {
+			goto _limit
 		}
 		state = 7
 		goto _next
@@ -165,7 +278,11 @@ _resume:
 			state = 11
 			goto _next
 		case '[':
+			if elems++; elems > lim.Elements {
+				goto _limit
+			}
 			state = 1
+			params = 0
 			goto _next
 		}
 		goto _err
@@ -185,6 +302,9 @@ _next:
 	}
 _out:
 	if state < 11 {
+		if cut {
+			goto _limit
+		}
 		goto _err
 	}
 	if edgerOk {
@@ -193,6 +313,327 @@ _out:
 	return
 _err:
 	return ErrStructData
+_limit:
+	return ErrStructDataLimit
+}
+
+// StructDataStream scans STRUCTURED-DATA fed to it in chunks,
+// so that the section doesn't have to be held in one slice.
+// It reports to the iterator as ScanStructData does, except that
+// tokens spanning chunks are handed over assembled in its own buffer,
+// which is valid until the next call of Scan. The zero value, without
+// an iterator, checks the section against DefaultStructDataLimits.
+type StructDataStream struct {
+	s       StructDataScanner
+	iter    StructDataIterator
+	err     error
+	buf     []byte
+	quoted  []byte
+	id      []byte
+	param   []byte
+	lim     StructDataLimits
+	total   int
+	elems   int
+	params  int
+	partial bool
+	state   uint8
+	vstate  uint8
+	vlen    uint8
+	vtyp    ValueType
+	vbad    int
+	vesc    int
+	kinds   ValueKind
+}
+
+// Scan consumes chunk and returns the number of bytes consumed.
+// Once the section and the SP following it have been consumed,
+// Done reports true and the rest of chunk is left unread.
+func (d *StructDataStream) Scan(chunk []byte) (n int, err error) {
+	var (
+		c    byte
+		eof  = len(chunk)
+		mark int
+		tok  []byte
+		cut  bool
+	)
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.lim.Size == 0 {
+		d.lim = d.s.Limits.resolve()
+	}
+	if d.state == 11 || eof == 0 {
+		return 0, nil
+	}
+	if eof > d.lim.Size-d.total {
+		eof, cut = d.lim.Size-d.total, true
+	}
+	if eof == 0 {
+		goto _limit
+	}
+_resume:
+	switch c = chunk[n]; d.state {
+	case 0:
+		switch c {
+		case '[':
+			d.state = 1
+			d.elems, d.params = 1, 0
+			if err = d.begin(); err != nil {
+				goto _fail
+			}
+			goto _next
+		case '-':
+			d.state = 12
+			goto _next
+		}
+		goto _err
+	case 12:
+		if c == ' ' {
+			d.state = 11
+			if err = d.begin(); err == nil {
+				err = d.end()
+			}
+			n++
+			goto _out
+		}
+		goto _err
+	case 1:
+		if ' ' < c && c <= '~' && c != '"' && c != '=' {
+			d.state = 2
+			goto _mark
+		}
+		goto _err
+	case 2:
+		switch c {
+		case ' ':
+			d.state = 3
+			d.id = append(d.id[:0], d.token(chunk, mark, n)...)
+			goto _next
+		case ']':
+			d.state = 10
+			d.id = append(d.id[:0], d.token(chunk, mark, n)...)
+			d.param, tok, d.vtyp = d.param[:0], chunk[:0], 0
+			goto _process
+		}
+		if ' ' < c && c <= '~' && c != '"' && c != '=' && d.size(mark, n) < 31 {
+			goto _next
+		}
+		goto _err
+	case 3:
+		if ' ' < c && c <= '~' && c != '"' && c != '=' {
+			if d.params++; d.params > d.lim.Params {
+				goto _limit
+			}
+			d.state = 4
+			goto _mark
+		}
+		goto _err
+	case 4:
+		if c == '=' {
+			d.state = 5
+			d.param = append(d.param[:0], d.token(chunk, mark, n)...)
+			if d.kinds = d.s.Kinds; d.s.ParamKinds != nil {
+				d.kinds = d.s.ParamKinds(d.id, d.param, d.kinds)
+			}
+			goto _next
+		}
+		if ' ' < c && c <= '~' && c != '"' && d.size(mark, n) < 31 {
+			goto _next
+		}
+		goto _err
+	case 5:
+		if c == '"' {
+			d.state = 6
+			d.vstate, d.vlen, d.vtyp, d.vbad, d.vesc = 1, 0, 0, 0, 0
+			goto _next
+		}
+		goto _err
+	case 6:
+		switch c {
+		case '"':
+			d.state = 9
+			tok = chunk[:0]
+			goto _process
+		case '\\':
+			d.state = 8
+			d.vstate = 0
+		default:
+			d.state = 7
+			valueType(&d.vstate, &d.vlen, c)
+		}
+		goto _mark
+	case 7:
+		switch c {
+		case '"':
+			d.state = 9
+			tok = d.token(chunk, mark, n)
+			if err = d.check(tok); err != nil {
+				goto _fail
+			}
+			if t := inferValue(tok, d.vstate, d.vlen, d.kinds); t != String {
+				d.vtyp = t
+			} else if d.vesc > 0 {
+				d.quoted = unescapeC(d.quoted[:0], tok, d.s.Quotes)
+				tok, d.vtyp = d.quoted, String
+			} else if d.s.Quotes {
+				d.quoted = append(append(append(d.quoted[:0], '"'), tok...), '"')
+				tok = d.quoted
+			}
+			goto _process
+		case '\\':
+			d.state = 8
+			d.vstate = 0
+			goto _next
+		}
+		if d.size(mark, n) >= d.lim.Value {
+			goto _limit
+		}
+		if d.vstate > 0 {
+			valueType(&d.vstate, &d.vlen, c)
+		}
+		goto _next
+	case 8:
+		if c == '"' || c == '\\' || c == ']' {
+			d.vtyp++
+		} else if d.vbad++; d.s.Escapes == EscapeC && isEscapeC(c) {
+			d.vesc++
+		}
+		if d.size(mark, n) >= d.lim.Value {
+			goto _limit
+		}
+		d.state = 7
+		goto _next
+	case 9:
+		switch c {
+		case ' ':
+			d.state = 3
+			goto _next
+		case ']':
+			d.state = 10
+			goto _next
+		}
+		goto _err
+	case 10:
+		switch c {
+		case ' ':
+			d.state = 11
+			err = d.end()
+			n++
+			goto _out
+		case '[':
+			if d.elems++; d.elems > d.lim.Elements {
+				goto _limit
+			}
+			d.state = 1
+			d.params = 0
+			goto _next
+		}
+		goto _err
+	}
+_process:
+	if d.iter == nil {
+		goto _next
+	}
+	if err = d.iter.StructDataEach(d.id, d.param, tok, d.vtyp); err != nil {
+		goto _fail
+	}
+	goto _next
+_mark:
+	mark = n
+_next:
+	if n++; n != eof {
+		goto _resume
+	}
+	switch d.state {
+	case 2, 4, 7, 8:
+		if !d.partial {
+			d.buf = d.buf[:0]
+		}
+		d.buf = append(d.buf, chunk[mark:eof]...)
+		d.partial = true
+	}
+	if cut {
+		goto _limit
+	}
+_out:
+	if d.total += n; err != nil {
+		goto _fail
+	}
+	return
+_err:
+	err = ErrStructData
+	goto _fail
+_limit:
+	err = ErrStructDataLimit
+_fail:
+	d.total += n
+	d.err = err
+	return
+}
+
+// Done reports whether the whole section has been scanned.
+func (d *StructDataStream) Done() bool {
+	return d.state == 11
+}
+
+// Close marks the end of input and reports an error
+// if the section is incomplete.
+func (d *StructDataStream) Close() error {
+	if d.err == nil && d.state != 11 {
+		d.err = ErrStructData
+	}
+	return d.err
+}
+
+// Reset discards the state of d and makes it ready to scan a new section.
+func (d *StructDataStream) Reset() {
+	d.err = nil
+	d.total = 0
+	d.partial = false
+	d.state = 0
+}
+
+func (d *StructDataStream) token(chunk []byte, mark, n int) []byte {
+	if d.partial {
+		d.partial = false
+		d.buf = append(d.buf, chunk[:n]...)
+		return d.buf
+	}
+	return chunk[mark:n]
+}
+
+func (d *StructDataStream) size(mark, n int) int {
+	if d.partial {
+		return len(d.buf) + n
+	}
+	return n - mark
+}
+
+func (d *StructDataStream) check(value []byte) error {
+	if checker, ok := d.iter.(StructDataChecker); ok {
+		if valid := utf8.Valid(value); d.vbad > 0 || !valid {
+			return checker.StructDataCheck(d.id, d.param, value, d.vbad, valid)
+		}
+	}
+	return nil
+}
+
+func (d *StructDataStream) begin() error {
+	if edger, ok := d.iter.(StructDataEdger); ok {
+		return edger.StructDataBegin()
+	}
+	return nil
+}
+
+func (d *StructDataStream) end() error {
+	if edger, ok := d.iter.(StructDataEdger); ok {
+		return edger.StructDataEnd()
+	}
+	return nil
+}
+
+func NewStructDataStream(s StructDataScanner, iter StructDataIterator) *StructDataStream {
+	return &StructDataStream{s: s, iter: iter, lim: s.Limits.resolve()}
 }
 
 func valueType(state, len *uint8, c byte) {
@@ -216,8 +657,11 @@ func valueType(state, len *uint8, c byte) {
 		case 't':
 			*state = 13
 			return
+		case '0':
+			*state = 22
+			return
 		}
-		if '0' <= c && c <= '9' {
+		if '1' <= c && c <= '9' {
 			*state = 18
 			return
 		}
@@ -230,8 +674,36 @@ func valueType(state, len *uint8, c byte) {
 			*state = 18
 			return
 		}
+	case 22:
+		if c == 'x' || c == 'X' {
+			*state = 23
+			return
+		}
+		if '0' <= c && c <= '9' {
+			*state = 18
+			*len++
+			return
+		}
+		if c == '.' {
+			*state = 3
+			return
+		}
+		if c == 'e' || c == 'E' {
+			*state = 4
+			return
+		}
+	case 23:
+		if isHex(c) {
+			*state = 24
+			return
+		}
+	case 24:
+		if isHex(c) && *len < 15 {
+			*len++
+			return
+		}
 	case 18:
-		if '0' <= c && c <= '9' && *len < 18 {
+		if '0' <= c && c <= '9' && *len < 19 {
 			*len++
 			return
 		}
@@ -319,6 +791,127 @@ func valueType(state, len *uint8, c byte) {
 	return
 }
 
+// inferValue returns the type of value, whose lexical state is vstate,
+// or String if the type is not one of kinds.
+func inferValue(value []byte, vstate, vlen uint8, kinds ValueKind) ValueType {
+	switch vstate {
+	case 16, 17:
+		if kinds&KindFloat != 0 {
+			return Float
+		}
+	case 18, 22:
+		if vlen < 18 {
+			if kinds&KindInt != 0 {
+				return Int
+			}
+			break
+		}
+		if _, ok := parseInt(value); ok {
+			if kinds&KindInt != 0 {
+				return Int
+			}
+			break
+		}
+		if _, ok := parseUint(value); ok && kinds&KindUint != 0 {
+			return Uint
+		}
+	case 24:
+		if kinds&KindHex == 0 {
+			break
+		}
+		if vlen < 15 || value[2] < '8' {
+			if kinds&KindInt != 0 {
+				return Int
+			}
+			break
+		}
+		if kinds&KindUint != 0 {
+			return Uint
+		}
+	case 19:
+		if kinds&KindNil != 0 {
+			return Nil
+		}
+	case 20:
+		if kinds&KindBool != 0 {
+			return False
+		}
+	case 21:
+		if kinds&KindBool != 0 {
+			return True
+		}
+	}
+	if kinds&KindTime != 0 && isTimestamp(value) {
+		return Time
+	}
+	if kinds&KindDuration != 0 && isDuration(value) {
+		return Duration
+	}
+	return String
+}
+
+// isTimestamp reports whether data is an RFC 3339 timestamp
+// with at most six digits of a fractional second.
+func isTimestamp(data []byte) bool {
+	pos := 0
+
+	if scanDate(data, &pos) != nil || scanTime(data, &pos) != nil {
+		return false
+	}
+	switch data = data[pos:]; len(data) {
+	case 1:
+		return data[0] == 'Z'
+	case 6:
+		return (data[0] == '+' || data[0] == '-') &&
+			'0' <= data[1] && data[1] <= '2' && '0' <= data[2] && data[2] <= '9' &&
+			data[3] == ':' &&
+			'0' <= data[4] && data[4] <= '5' && '0' <= data[5] && data[5] <= '9'
+	}
+	return false
+}
+
+// isDuration reports whether data is a duration
+// as accepted by time.ParseDuration, e.g. 1h30m or -2.5s.
+func isDuration(data []byte) bool {
+	if len(data) > 0 && (data[0] == '-' || data[0] == '+') {
+		data = data[1:]
+	}
+	if len(data) == 0 {
+		return false
+	}
+	for len(data) > 0 {
+		i, dot := 0, false
+
+		for ; i < len(data); i++ {
+			if c := data[i]; c == '.' && !dot {
+				dot = true
+			} else if '0' > c || c > '9' {
+				break
+			}
+		}
+		if i == 0 || (dot && i == 1) {
+			return false
+		}
+		data = data[i:]
+
+		switch {
+		case len(data) > 1 && data[1] == 's' && (data[0] == 'n' || data[0] == 'u' || data[0] == 'm'):
+			data = data[2:]
+		case len(data) > 2 && data[2] == 's' && (data[0] == 0xc2 && data[1] == 0xb5 || data[0] == 0xce && data[1] == 0xbc):
+			data = data[3:]
+		case len(data) > 0 && (data[0] == 'h' || data[0] == 'm' || data[0] == 's'):
+			data = data[1:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
 func ParseValue(value []byte, vtyp ValueType) interface{} {
 	if len(value) == 0 || (len(value) == 2 && value[0] == '"') {
 		return ``
@@ -353,6 +946,24 @@ func ParseValue(value []byte, vtyp ValueType) interface{} {
 			goto _ret
 		}
 		return v
+	case Uint:
+		v, ok := parseUint(value)
+		if !ok {
+			goto _ret
+		}
+		return v
+	case Time:
+		v, e := time.Parse(time.RFC3339Nano, bytesToStr(&value))
+		if e != nil {
+			goto _ret
+		}
+		return v
+	case Duration:
+		v, e := time.ParseDuration(bytesToStr(&value))
+		if e != nil {
+			goto _ret
+		}
+		return v
 	}
 _ret:
 	return string(value)
@@ -367,15 +978,58 @@ func parseInt(data []byte) (n int64, ok bool) {
 		ok = c == '-'
 		data = data[1:]
 	}
-	for _, c = range data {
-		if '0' > c || c > '9' {
+	u, valid := parseDigits(data)
+	if !valid || (ok && u > 1<<63) || (!ok && u > 1<<63-1) {
+		return 0, false
+	}
+	if ok {
+		return -int64(u), true
+	}
+	return int64(u), true
+}
+
+func parseUint(data []byte) (uint64, bool) {
+	if len(data) > 0 && data[0] == '+' {
+		data = data[1:]
+	}
+	return parseDigits(data)
+}
+
+func parseDigits(data []byte) (n uint64, ok bool) {
+	if len(data) > 2 && data[0] == '0' && (data[1] == 'x' || data[1] == 'X') {
+		return parseHex(data[2:])
+	}
+	if len(data) == 0 {
+		return 0, false
+	}
+	for _, c := range data {
+		if '0' > c || c > '9' || n > (1<<64-1)/10 {
 			return 0, false
 		}
 		n *= 10
-		n += int64(c - '0')
+		if n += uint64(c - '0'); n < uint64(c-'0') {
+			return 0, false
+		}
 	}
-	if ok {
-		return -n, true
+	return n, true
+}
+
+func parseHex(data []byte) (n uint64, ok bool) {
+	if len(data) == 0 || len(data) > 16 {
+		return 0, false
+	}
+	for _, c := range data {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		n = n<<4 | uint64(c)
 	}
 	return n, true
 }
@@ -400,6 +1054,12 @@ func ParseStructData(data []byte, pos *int, m map[string]interface{}) error {
 	return ScanStructData(data, pos, false, &structDataMap{m: m})
 }
 
+func (s *StructDataScanner) Parse(data []byte, pos *int, m map[string]interface{}) error {
+	c := *s
+	c.Quotes = false
+	return c.Scan(data, pos, &structDataMap{m: m})
+}
+
 func EscapeCount(data []byte) (n int) {
 	for _, c := range data {
 		if c == '"' || c == '\\' || c == ']' {
@@ -413,17 +1073,21 @@ func Escape(data []byte, n int) []byte {
 	if len(data) == 0 || n < 1 {
 		return data
 	}
-	r, i := make([]byte, len(data)+n), 0
+	return AppendEscape(make([]byte, 0, len(data)+n), data)
+}
 
-	for _, c := range data {
-		if c == '"' || c == '\\' || c == ']' {
-			r[i] = '\\'
-			i++
+// AppendEscape appends src escaped as a PARAM-VALUE to dst
+// and returns the extended buffer.
+func AppendEscape(dst, src []byte) []byte {
+	for {
+		i := bytes.IndexAny(src, escapable)
+		if i < 0 {
+			return append(dst, src...)
 		}
-		r[i] = c
-		i++
+		dst = append(dst, src[:i]...)
+		dst = append(dst, '\\', src[i])
+		src = src[i+1:]
 	}
-	return r
 }
 
 func UnescapeCount(data []byte) (n int) {
@@ -447,22 +1111,129 @@ func Unescape(data []byte, n int) []byte {
 	if len(data) == 0 || n < 1 {
 		return data
 	}
-	r, i, p := make([]byte, len(data)-n), 0, byte(0)
+	return AppendUnescape(make([]byte, 0, len(data)-n), data)
+}
 
-	for _, c := range data {
-		if p == '\\' && (c == '"' || c == ']') {
-			i--
+// AppendUnescape appends the unescaped PARAM-VALUE src to dst
+// and returns the extended buffer. A backslash that doesn't start
+// an escape sequence is taken literally.
+func AppendUnescape(dst, src []byte) []byte {
+	for {
+		i := bytes.IndexByte(src, '\\')
+		if i < 0 || i+1 == len(src) {
+			return append(dst, src...)
 		}
-		if p == '\\' && c == '\\' {
-			i--
-			p = byte(0)
+		if c := src[i+1]; c == '"' || c == '\\' || c == ']' {
+			dst = append(dst, src[:i]...)
+			dst = append(dst, c)
+			src = src[i+2:]
 		} else {
-			p = c
+			dst = append(dst, src[:i+1]...)
+			src = src[i+1:]
 		}
-		r[i] = c
-		i++
 	}
-	return r
+}
+
+// UnescapeInPlace unescapes the PARAM-VALUE data in its own memory
+// and returns the shortened slice.
+func UnescapeInPlace(data []byte) []byte {
+	return AppendUnescape(data[:0], data)
+}
+
+// AppendUnescapeC appends the PARAM-VALUE src to dst, interpreting
+// the escape sequences of EscapeC, and returns the extended buffer.
+func AppendUnescapeC(dst, src []byte) []byte {
+	for {
+		i := bytes.IndexByte(src, '\\')
+		if i < 0 || i+1 == len(src) {
+			return append(dst, src...)
+		}
+		dst = append(dst, src[:i]...)
+
+		switch c := src[i+1]; c {
+		case '"', '\\', ']', '\'':
+			dst = append(dst, c)
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case '0':
+			dst = append(dst, 0)
+		case 'a':
+			dst = append(dst, '\a')
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'v':
+			dst = append(dst, '\v')
+		default:
+			dst = append(dst, '\\')
+			src = src[i+1:]
+			continue
+		}
+		src = src[i+2:]
+	}
+}
+
+func unescapeC(dst, value []byte, quotes bool) []byte {
+	if quotes {
+		dst = append(dst, '"')
+	}
+	if dst = AppendUnescapeC(dst, value); quotes {
+		dst = append(dst, '"')
+	}
+	return dst
+}
+
+func isEscapeC(c byte) bool {
+	switch c {
+	case 'n', 'r', 't', '0', 'a', 'b', 'f', 'v', '\'':
+		return true
+	}
+	return false
+}
+
+// EscapeWriter escapes PARAM-VALUE bytes on the fly
+// while writing them to the underlying io.Writer.
+type EscapeWriter struct {
+	w       io.Writer
+	scratch [2]byte
+}
+
+// Write writes the escaped p and returns the number of bytes of p written.
+func (e *EscapeWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		m, i := 0, bytes.IndexAny(p, escapable)
+		if i < 0 {
+			m, err = e.w.Write(p)
+			return n + m, err
+		}
+		if i > 0 {
+			m, err = e.w.Write(p[:i])
+			if n += m; err != nil {
+				return
+			}
+		}
+		e.scratch[0], e.scratch[1] = '\\', p[i]
+		if _, err = e.w.Write(e.scratch[:]); err != nil {
+			return
+		}
+		n++
+		p = p[i+1:]
+	}
+	return
+}
+
+// Reset resets e to write its output to w.
+func (e *EscapeWriter) Reset(w io.Writer) {
+	e.w = w
+}
+
+func NewEscapeWriter(w io.Writer) *EscapeWriter {
+	return &EscapeWriter{w: w}
 }
 
 func IsIdent(id string) bool {
@@ -496,6 +1267,12 @@ func (t ValueType) String() string {
 		return `float`
 	case Int:
 		return `int`
+	case Uint:
+		return `uint`
+	case Time:
+		return `time`
+	case Duration:
+		return `duration`
 	}
 	return `unknown`
 }
@@ -507,8 +1284,33 @@ const (
 	True
 	Float
 	Int
+	Uint
+	Time
+	Duration
+)
+
+// ValueKind is a set of value kinds inferred from a PARAM-VALUE.
+// A value of a kind that is not in the set is reported as a string.
+type ValueKind uint16
+
+const (
+	KindNil      ValueKind = 1 << iota // null
+	KindBool                           // true, false
+	KindInt                            // decimal integer in the int64 range
+	KindFloat                          // decimal floating point number
+	KindUint                           // decimal integer beyond the int64 range, up to the uint64 one
+	KindHex                            // 0x prefixed hexadecimal integer
+	KindTime                           // RFC 3339 timestamp
+	KindDuration                       // duration as accepted by time.ParseDuration
+
+	NoKinds      ValueKind = 0
+	DefaultKinds           = KindNil | KindBool | KindInt | KindFloat
+	AllKinds               = DefaultKinds | KindUint | KindHex | KindTime | KindDuration
 )
 
+const escapable = `"\]`
+
 var (
-	ErrStructData = errors.New(`invalid structured data`)
+	ErrStructData      = errors.New(`invalid structured data`)
+	ErrStructDataLimit = errors.New(`structured data limit exceeded`)
 )
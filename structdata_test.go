@@ -3,7 +3,9 @@ package syslogp
 import (
 	"bytes"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func Test_EscapeCount(t *testing.T) {
@@ -137,6 +139,13 @@ func Test_ParseValue(t *testing.T) {
 		{[]byte(`+256`), Int, int64(256)},
 		{[]byte(`1234567890123456789`), Int, int64(1234567890123456789)},
 		{[]byte(`12345678901234567890`), String, `12345678901234567890`},
+		{[]byte(`9223372036854775808`), Int, `9223372036854775808`},
+		{[]byte(`0x7fffffffffffffff`), Int, int64(9223372036854775807)},
+		{[]byte(`18446744073709551615`), Uint, uint64(18446744073709551615)},
+		{[]byte(`18446744073709551616`), Uint, `18446744073709551616`},
+		{[]byte(`0xFFFFFFFFFFFFFFFF`), Uint, uint64(18446744073709551615)},
+		{[]byte(`2003-10-11T22:14:15.003Z`), Time, time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC)},
+		{[]byte(`1h30m`), Duration, 90 * time.Minute},
 		{[]byte{}, String, ``},
 		{[]byte(`simple`), String, `simple`},
 		{[]byte(`two \"double quotes\"`), 2, `two "double quotes"`},
@@ -144,7 +153,7 @@ func Test_ParseValue(t *testing.T) {
 	}
 	for _, c := range cases {
 		out := ParseValue(c.in, c.typ)
-		if c.exp == out {
+		if c.exp != out {
 			t.Errorf("\n\tfor: %v %s(%s)\n\texp: %T(%v)\n\tgot: %T(%v)\n", c.in, c.typ, c.in, c.exp, c.exp, out, out)
 		}
 	}
@@ -215,3 +224,262 @@ func Test_ParseStructData(t *testing.T) {
 		}
 	}
 }
+
+func Test_StructDataScanner(t *testing.T) {
+	override := func(id, param []byte, kinds ValueKind) ValueKind {
+		if string(id) == `id2` || string(param) == `param2` {
+			return NoKinds
+		}
+		return kinds
+	}
+	cases := [...]struct {
+		in     []byte
+		kinds  ValueKind
+		params func([]byte, []byte, ValueKind) ValueKind
+		exp    map[string]interface{}
+	}{
+		{
+			[]byte(`[id1 p1="007" p2="true" p3="1e5" p4="null"] `), NoKinds, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{`p1`: `007`, `p2`: `true`, `p3`: `1e5`, `p4`: `null`}},
+		},
+		{
+			[]byte(`[id1 p1="007" p2="true" p3="1e5" p4="null"] `), KindBool | KindNil, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{`p1`: `007`, `p2`: true, `p3`: `1e5`, `p4`: nil}},
+		},
+		{
+			[]byte(`[id1 p1="0x1F" p2="18446744073709551615" p3="-18446744073709551615" p4="0x"] `), DefaultKinds, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{
+				`p1`: `0x1F`, `p2`: `18446744073709551615`, `p3`: `-18446744073709551615`, `p4`: `0x`,
+			}},
+		},
+		{
+			[]byte(`[id1 p1="0x1F" p2="18446744073709551615" p3="-18446744073709551615" p4="0x"] `), AllKinds, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{
+				`p1`: int64(31), `p2`: uint64(18446744073709551615), `p3`: `-18446744073709551615`, `p4`: `0x`,
+			}},
+		},
+		{
+			[]byte(`[id1 p1="2003-10-11T22:14:15.003-07:00" p2="2003-10-11" p3="-1.5h" p4="5µs" p5="1x"] `), AllKinds, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{
+				`p1`: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.FixedZone(``, -7*3600)),
+				`p2`: `2003-10-11`, `p3`: -90 * time.Minute, `p4`: 5 * time.Microsecond, `p5`: `1x`,
+			}},
+		},
+		{
+			[]byte(`[id1 p1="1\\2" p2="\\1"] `), DefaultKinds, nil,
+			map[string]interface{}{`id1`: map[string]interface{}{`p1`: `1\2`, `p2`: `\1`}},
+		},
+		{
+			[]byte(`[id1 param1="1" param2="2"][id2 param1="3"] `), DefaultKinds, override,
+			map[string]interface{}{
+				`id1`: map[string]interface{}{`param1`: int64(1), `param2`: `2`},
+				`id2`: map[string]interface{}{`param1`: `3`},
+			},
+		},
+	}
+	for _, c := range cases {
+		pos, out := 0, make(map[string]interface{})
+		s := StructDataScanner{Kinds: c.kinds, ParamKinds: c.params}
+		err := s.Parse(c.in, &pos, out)
+		if !reflect.DeepEqual(c.exp, out) || len(c.in) != pos || err != nil {
+			t.Errorf("\n\tfor: %s, kinds = %#x\n\texp: %v, %d\n\tgot: %v, %d, %v\n",
+				c.in, c.kinds, c.exp, len(c.in), out, pos, err)
+		}
+	}
+}
+
+func Test_StructDataStream(t *testing.T) {
+	cases := [...]struct {
+		in  []byte
+		n   int
+		err error
+	}{
+		{[]byte(`- msg`), 2, nil},
+		{[]byte(`-msg`), 1, ErrStructData},
+		{[]byte(`[id1] msg`), 6, nil},
+		{[]byte(`[id1]`), 5, ErrStructData},
+		{[]byte(`[id1][id2][id3] `), 16, nil},
+		{[]byte(`[id1 param1="true" param2="false" param3="null"] msg`), 49, nil},
+		{[]byte(`[id1 param1="3.14" param2="-1" param3=""][id2 param1="word"] msg`), 61, nil},
+		{[]byte(`[id1 param1="two \"double quotes\"" param2="three\\\\\\backslash"] msg`), 67, nil},
+		{[]byte(`[0123456789abcdef0123456789abcdefX] `), 32, ErrStructData},
+	}
+	for _, c := range cases {
+		pos, exp := 0, make(map[string]interface{})
+		ParseStructData(c.in, &pos, exp)
+
+		for size := 1; size <= len(c.in); size++ {
+			out := make(map[string]interface{})
+			d := NewStructDataStream(StructDataScanner{Kinds: DefaultKinds}, &structDataMap{m: out})
+			n, err := 0, error(nil)
+
+			for i := 0; i < len(c.in) && !d.Done() && err == nil; i += size {
+				var m int
+				m, err = d.Scan(c.in[i:min(i+size, len(c.in))])
+				n += m
+			}
+			if err == nil {
+				err = d.Close()
+			}
+			if !reflect.DeepEqual(exp, out) || c.n != n || c.err != err {
+				t.Errorf("\n\tfor: %s, chunk size = %d\n\texp: %v, %d, %v\n\tgot: %v, %d, %v\n",
+					c.in, size, exp, c.n, c.err, out, n, err)
+			}
+		}
+	}
+	for _, c := range cases {
+		var d StructDataStream
+
+		n, err := d.Scan(c.in)
+		if err == nil {
+			err = d.Close()
+		}
+		if c.n != n || c.err != err {
+			t.Errorf("\n\tfor: %s, zero value\n\texp: %d, %v\n\tgot: %d, %v\n", c.in, c.n, c.err, n, err)
+		}
+	}
+}
+
+func Test_StructDataLimits(t *testing.T) {
+	cases := [...]struct {
+		in  []byte
+		lim StructDataLimits
+		err error
+	}{
+		{[]byte(`[id1][id2][id3] `), StructDataLimits{Elements: 3}, nil},
+		{[]byte(`[id1][id2][id3] `), StructDataLimits{Elements: 2}, ErrStructDataLimit},
+		{[]byte(`[id1 a="1" b="2"][id2 a="1" b="2"] `), StructDataLimits{Params: 2}, nil},
+		{[]byte(`[id1 a="1" b="2" c="3"] `), StructDataLimits{Params: 2}, ErrStructDataLimit},
+		{[]byte(`[id1 a="1234"] `), StructDataLimits{Value: 4}, nil},
+		{[]byte(`[id1 a="12345"] `), StructDataLimits{Value: 4}, ErrStructDataLimit},
+		{[]byte(`[id1 a="1\]4"] `), StructDataLimits{Value: 4}, nil},
+		{[]byte(`[id1 a="12\]4"] `), StructDataLimits{Value: 4}, ErrStructDataLimit},
+		{[]byte(`[id1 a="1"] msg`), StructDataLimits{Size: 12}, nil},
+		{[]byte(`[id1 a="1"] msg`), StructDataLimits{Size: 11}, ErrStructDataLimit},
+		{[]byte(`[id1 a="1"]`), StructDataLimits{Size: 11}, ErrStructData},
+		{[]byte(`[id1][id2][id3] `), StructDataLimits{Elements: -1, Params: -1, Size: -1, Value: -1}, nil},
+	}
+	for _, c := range cases {
+		s := StructDataScanner{Limits: c.lim}
+
+		pos := 0
+		if err := s.Parse(c.in, &pos, make(map[string]interface{})); err != c.err {
+			t.Errorf("\n\tfor: %s, %+v\n\texp: %v\n\tgot: %v\n", c.in, c.lim, c.err, err)
+		}
+		d := NewStructDataStream(s, &structDataMap{m: make(map[string]interface{})})
+		_, err := d.Scan(c.in)
+		if err == nil {
+			err = d.Close()
+		}
+		if err != c.err {
+			t.Errorf("\n\tfor: %s, %+v, stream\n\texp: %v\n\tgot: %v\n", c.in, c.lim, c.err, err)
+		}
+	}
+}
+
+func Test_AppendEscape(t *testing.T) {
+	cases := [...]struct {
+		in  []byte
+		exp []byte
+	}{
+		{nil, []byte(`>`)},
+		{[]byte(`simple`), []byte(`>simple`)},
+		{[]byte(`two "double quotes"`), []byte(`>two \"double quotes\"`)},
+		{[]byte(`three\\\backslash`), []byte(`>three\\\\\\backslash`)},
+		{[]byte(`four [right]bracket]]]`), []byte(`>four [right\]bracket\]\]\]`)},
+	}
+	for _, c := range cases {
+		out := AppendEscape([]byte(`>`), c.in)
+		if !bytes.Equal(c.exp, out) {
+			t.Errorf("\n\tfor: %s\n\texp: %s\n\tgot: %s\n", c.in, c.exp, out)
+		}
+		b := new(bytes.Buffer)
+		n, err := NewEscapeWriter(b).Write(c.in)
+		if !bytes.Equal(c.exp[1:], b.Bytes()) || n != len(c.in) || err != nil {
+			t.Errorf("\n\tfor: %s, writer\n\texp: %s, %d\n\tgot: %s, %d, %v\n", c.in, c.exp[1:], len(c.in), b.Bytes(), n, err)
+		}
+	}
+}
+
+func Test_AppendUnescape(t *testing.T) {
+	cases := [...]struct {
+		in  []byte
+		exp []byte
+	}{
+		{nil, []byte(`>`)},
+		{[]byte(`simple`), []byte(`>simple`)},
+		{[]byte(`double"quote w/o escapes`), []byte(`>double"quote w/o escapes`)},
+		{[]byte(`two \"double quotes\"`), []byte(`>two "double quotes"`)},
+		{[]byte(`three\\\\\\backslash`), []byte(`>three\\\backslash`)},
+		{[]byte(`four [right\]bracket\]\]\]`), []byte(`>four [right]bracket]]]`)},
+		{[]byte(`\\\\\\\"\]`), []byte(`>\\\"]`)},
+		{[]byte(`literal \n and trailing \`), []byte(`>literal \n and trailing \`)},
+	}
+	for _, c := range cases {
+		out := AppendUnescape([]byte(`>`), c.in)
+		if !bytes.Equal(c.exp, out) {
+			t.Errorf("\n\tfor: %s\n\texp: %s\n\tgot: %s\n", c.in, c.exp, out)
+		}
+		in := append([]byte(nil), c.in...)
+		if out = UnescapeInPlace(in); !bytes.Equal(c.exp[1:], out) {
+			t.Errorf("\n\tfor: %s, in place\n\texp: %s\n\tgot: %s\n", c.in, c.exp[1:], out)
+		}
+	}
+}
+
+type structDataCheck struct {
+	structDataMap
+	checks []string
+}
+
+func (c *structDataCheck) StructDataCheck(id, param, value []byte, escapes int, validUTF8 bool) error {
+	c.checks = append(c.checks, string(param)+`:`+strconv.Itoa(escapes)+`:`+strconv.FormatBool(validUTF8))
+	return nil
+}
+
+func Test_StructDataChecker(t *testing.T) {
+	cases := [...]struct {
+		in     []byte
+		mode   EscapeMode
+		exp    map[string]interface{}
+		checks []string
+	}{
+		{
+			[]byte("[id a=\"plain\" b=\"\\\"quoted\\\"\" c=\"tab\\there\" d=\"bad\xff\"] "), EscapeRFC,
+			map[string]interface{}{`id`: map[string]interface{}{`a`: `plain`, `b`: `"quoted"`, `c`: `tab\there`, `d`: "bad\xff"}},
+			[]string{`c:1:true`, `d:0:false`},
+		},
+		{
+			[]byte("[id a=\"caf\xc3\xa9\" b=\"caf\xe9\"] "), EscapeRFC,
+			map[string]interface{}{`id`: map[string]interface{}{`a`: "caf\xc3\xa9", `b`: "caf\xe9"}},
+			[]string{`b:0:false`},
+		},
+		{
+			[]byte(`[id a="line\nnext\\n" b="\q\0" c="\"\]"] `), EscapeC,
+			map[string]interface{}{`id`: map[string]interface{}{`a`: "line\nnext\\n", `b`: "\\q\x00", `c`: `"]`}},
+			[]string{`a:1:true`, `b:2:true`},
+		},
+	}
+	for _, c := range cases {
+		s := StructDataScanner{Escapes: c.mode}
+		out := &structDataCheck{structDataMap: structDataMap{m: make(map[string]interface{})}}
+
+		pos := 0
+		err := s.Scan(c.in, &pos, out)
+		if !reflect.DeepEqual(c.exp, out.m) || !reflect.DeepEqual(c.checks, out.checks) || err != nil {
+			t.Errorf("\n\tfor: %s\n\texp: %v, %q\n\tgot: %v, %q, %v\n", c.in, c.exp, c.checks, out.m, out.checks, err)
+		}
+		out = &structDataCheck{structDataMap: structDataMap{m: make(map[string]interface{})}}
+		d, err := NewStructDataStream(s, out), error(nil)
+
+		for i := 0; i < len(c.in) && err == nil; i++ {
+			_, err = d.Scan(c.in[i : i+1])
+		}
+		if err == nil {
+			err = d.Close()
+		}
+		if !reflect.DeepEqual(c.exp, out.m) || !reflect.DeepEqual(c.checks, out.checks) || err != nil {
+			t.Errorf("\n\tfor: %s, stream\n\texp: %v, %q\n\tgot: %v, %q, %v\n", c.in, c.exp, c.checks, out.m, out.checks, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package syslogp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Server receives syslog messages over TCP (RFC 6587), or any other stream
+// a net.Listener accepts, reading the frames of every connection with
+// a FrameScanner that detects their framing, and hands them over to Handler
+// parsed by ParseMessage.
+type Server struct {
+	// Handler handles the messages received. It is called concurrently
+	// for different connections.
+	Handler Handler
+
+	// Trailer delimits non-transparent frames.
+	Trailer Trailer
+
+	// MaxConns is the number of connections served at once, unlimited
+	// if zero. Connections over it are closed as soon as accepted.
+	MaxConns int
+
+	// MaxFrameSize is the size of the largest frame accepted, unlimited
+	// if zero. Larger frames are skipped.
+	MaxFrameSize int
+
+	// BufferSize is the size of the buffer of a connection, 8 KiB if zero.
+	// It grows up to MaxFrameSize for larger frames, which are truncated
+	// without MaxFrameSize.
+	BufferSize int
+
+	// IdleTimeout and FrameTimeout are the timeouts of FrameScanner.SetTimeouts,
+	// the time a connection may wait for a frame and for the rest of a frame.
+	IdleTimeout  time.Duration
+	FrameTimeout time.Duration
+
+	// Pool, if not nil, lends the buffers connections read frames into,
+	// see FrameScanner.SetPool. Its buffers must not be less than 64 bytes.
+	Pool *BufferPool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]*drain
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closed    bool
+}
+
+// ListenAndServe listens on the TCP address and serves it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen(`tcp`, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves each of them in a goroutine
+// until l is closed, when it returns nil, or fails to accept, when it closes
+// l and returns the error. Temporary failures, such as running out of file
+// descriptors, are reported to Handler, if it is an ErrorHandler, and
+// retried after a delay growing from 5 ms to 1 s.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.track(l, false)
+
+	var delay time.Duration
+
+	for {
+		c, err := l.Accept()
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if !temporary(err) {
+				l.Close()
+				return err
+			}
+			if e, ok := s.Handler.(ErrorHandler); ok {
+				e.HandleError(nil, l.Addr(), err)
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		d, ctx, err := s.open(c)
+
+		if err != nil {
+			if c.Close(); err == ErrConnLimit {
+				if e, ok := s.Handler.(ErrorHandler); ok {
+					e.HandleError(nil, c.RemoteAddr(), err)
+				}
+			}
+			continue
+		}
+		go s.serve(ctx, c, d)
+	}
+}
+
+func (s *Server) serve(ctx context.Context, c net.Conn, d *drain) {
+	defer s.close(c)
+
+	size := s.BufferSize
+
+	if s.Pool != nil {
+		size = 64
+	} else if size <= 0 {
+		size = 8 << 10
+	}
+	f := NewAutoFrameScanner(c, make([]byte, size), s.MaxFrameSize, s.Trailer)
+	f.Grow(true)
+	f.SetTimeouts(s.IdleTimeout, s.FrameTimeout)
+
+	if s.MaxFrameSize > 0 {
+		f.SkipExceeded(nil)
+	}
+	if s.Pool != nil {
+		f.SetPool(s.Pool)
+	}
+	f.drain = d
+	m := Message{Addr: c.RemoteAddr(), Cred: peerCred(c)}
+
+	for f.NextContext(ctx) && ctx.Err() == nil {
+		m.Received = time.Now()
+		deliver(s.Handler, &m, f.Bytes())
+	}
+	if err := f.Err(); err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		if e, ok := s.Handler.(ErrorHandler); ok {
+			e.HandleError(nil, m.Addr, err)
+		}
+	}
+}
+
+// temporary reports whether err, failing to accept a connection, may not
+// fail the next accept: the file descriptors ran out, or the connection
+// was aborted before accepted.
+func temporary(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// Shutdown stops s gracefully: it closes the listeners, then every
+// connection once the frames it has begun to send are read and handled,
+// and waits for that. If ctx is done first, Shutdown closes the connections
+// left, like Close, and returns ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.closeListeners()
+
+	for _, d := range s.conns {
+		d.stop()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and the connections served at once,
+// dropping the frames being read.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	err := s.closeListeners()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for c := range s.conns {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *Server) closeListeners() (err error) {
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (s *Server) track(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// open registers the connection c, unless s is closed or serves MaxConns.
+func (s *Server) open(c net.Conn) (*drain, context.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, nil, ErrServerClosed
+	}
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		return nil, nil, ErrConnLimit
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]*drain)
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	d := &drain{d: c}
+	s.conns[c] = d
+	s.wg.Add(1)
+	return d, s.ctx, nil
+}
+
+func (s *Server) close(c net.Conn) {
+	c.Close()
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+var (
+	ErrServerClosed = errors.New(`server closed`)
+	ErrConnLimit    = errors.New(`connection limit reached`)
+)
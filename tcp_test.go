@@ -0,0 +1,315 @@
+package syslogp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, s *Server) (string, chan error) {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Skip(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	return l.Addr().String(), done
+}
+
+func dial(t *testing.T, addr string, frames ...string) net.Conn {
+	c, err := net.Dial(`tcp`, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range frames {
+		if _, err = c.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func closed(t *testing.T, c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("\n\tfor: connection closed by server\n\texp: %v\n\tgot: %v\n", io.EOF, err)
+	}
+}
+
+func Test_Server(t *testing.T) {
+	h := newCollectHandler()
+	s := &Server{Handler: h, BufferSize: 16, MaxFrameSize: 256}
+	addr, done := startServer(t, s)
+
+	a := dial(t, addr,
+		`53 <34>1 2003-10-11T22:14:15.003Z host su - ID47 - first`,
+		"<13>Oct 11 22:14:15 sshd[1234]: second\n",
+		"\n10 <999>x y z",
+	)
+	defer a.Close()
+
+	b := dial(t, addr, "<13>Oct 11 22:14:15 cron: third\n300 <13>")
+	defer b.Close()
+
+	h.wait(t, 4)
+	b.Write(make([]byte, 296))
+	b.Write([]byte("<13>Oct 11 22:14:15 cron: fourth\n"))
+	h.wait(t, 1)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("\n\tfor: Shutdown\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("\n\tfor: Serve\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	closed(t, a)
+	closed(t, b)
+
+	exp := []string{`cron: fourth`, `cron: third`, `sshd: second`, `su: first`}
+	expErrs := []string{`<999>x y z: invalid priority`}
+
+	if out, errs := h.sorted(); !reflect.DeepEqual(exp, out) || !reflect.DeepEqual(expErrs, errs) {
+		t.Errorf("\n\tfor: Server\n\texp: %q, %q\n\tgot: %q, %q\n", exp, expErrs, out, errs)
+	}
+	for _, addr := range h.addrs {
+		if addr.String() != a.LocalAddr().String() && addr.String() != b.LocalAddr().String() {
+			t.Errorf("\n\tfor: source address\n\texp: %s or %s\n\tgot: %s\n", a.LocalAddr(), b.LocalAddr(), addr)
+		}
+	}
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(l); err != ErrServerClosed {
+		t.Errorf("\n\tfor: Serve after Shutdown\n\texp: %v\n\tgot: %v\n", ErrServerClosed, err)
+	}
+}
+
+func Test_ServerShutdown(t *testing.T) {
+	h := newCollectHandler()
+	s := &Server{Handler: h, Pool: NewBufferPool(64, 1<<10)}
+	addr, done := startServer(t, s)
+
+	a := dial(t, addr, "<13>Oct 11 22:14:15 a: 1\n")
+	defer a.Close()
+
+	b := dial(t, addr, "<13>Oct 11 22:14:15 b: 1\n")
+	defer b.Close()
+
+	h.wait(t, 2)
+	a.Write([]byte(`24 <13>Oct 11 22:14:15 a:`))
+	time.Sleep(20 * time.Millisecond)
+
+	shut := make(chan error, 1)
+	go func() { shut <- s.Shutdown(context.Background()) }()
+
+	closed(t, b)
+	select {
+	case err := <-shut:
+		t.Fatalf("\n\tfor: Shutdown with a frame in flight\n\texp: waiting\n\tgot: %v\n", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	a.Write([]byte(" 2\n"))
+	h.wait(t, 1)
+
+	if err := <-shut; err != nil {
+		t.Errorf("\n\tfor: Shutdown\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	<-done
+	closed(t, a)
+
+	exp := []string{`a: 1`, `a: 2`, `b: 1`}
+
+	if out, errs := h.sorted(); !reflect.DeepEqual(exp, out) || len(errs) > 0 {
+		t.Errorf("\n\tfor: Shutdown\n\texp: %q\n\tgot: %q, %q\n", exp, out, errs)
+	}
+}
+
+// stallListener accepts connections that, once stall is closed, start
+// shut when about to arm a read deadline and give it time to stop them.
+type stallListener struct {
+	net.Listener
+	stall chan struct{}
+	shut  func()
+	fired atomic.Bool
+}
+
+func (l *stallListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return stallConn{c, l}, nil
+}
+
+type stallConn struct {
+	net.Conn
+	l *stallListener
+}
+
+func (c stallConn) SetReadDeadline(t time.Time) error {
+	select {
+	case <-c.l.stall:
+		if c.l.fired.CompareAndSwap(false, true) {
+			go c.l.shut()
+			time.Sleep(50 * time.Millisecond)
+		}
+	default:
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func Test_ServerShutdownIdle(t *testing.T) {
+	h := newCollectHandler()
+	s := &Server{Handler: h}
+	shut := make(chan error, 1)
+
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Skip(err)
+	}
+	l := &stallListener{Listener: ln, stall: make(chan struct{})}
+	l.shut = func() { shut <- s.Shutdown(context.Background()) }
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	defer s.Close()
+
+	c := dial(t, ln.Addr().String(), "<13>Oct 11 22:14:15 a: 1\n")
+	defer c.Close()
+
+	h.wait(t, 1)
+	close(l.stall)
+	c.Write([]byte("<13>Oct 11 22:14:15 a: 2\n"))
+
+	select {
+	case err := <-shut:
+		if err != nil {
+			t.Errorf("\n\tfor: Shutdown with an idle connection\n\texp: %v\n\tgot: %v\n", nil, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("\n\tfor: Shutdown with an idle connection\n\texp: %v\n\tgot: hung\n", nil)
+	}
+	<-done
+	closed(t, c)
+
+	if out, errs := h.sorted(); len(out) != 2 || len(errs) > 0 {
+		t.Errorf("\n\tfor: Shutdown with an idle connection\n\texp: %q\n\tgot: %q, %q\n", []string{`a: 1`, `a: 2`}, out, errs)
+	}
+}
+
+// failListener fails to accept with errs, one at a time, before accepting
+// connections.
+type failListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *failListener) Accept() (net.Conn, error) {
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	return l.Listener.Accept()
+}
+
+func Test_ServerAcceptError(t *testing.T) {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Skip(err)
+	}
+	emfile := &net.OpError{Op: `accept`, Net: `tcp`, Err: os.NewSyscallError(`accept`, syscall.EMFILE)}
+	h := newCollectHandler()
+	s := &Server{Handler: h}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(&failListener{ln, []error{emfile}}) }()
+
+	c := dial(t, ln.Addr().String(), "<13>Oct 11 22:14:15 a: 1\n")
+	defer c.Close()
+
+	h.wait(t, 2)
+	s.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("\n\tfor: Serve after a temporary error\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	expErrs := []string{`: ` + emfile.Error()}
+
+	if out, errs := h.sorted(); !reflect.DeepEqual([]string{`a: 1`}, out) || !reflect.DeepEqual(expErrs, errs) {
+		t.Errorf("\n\tfor: Serve after a temporary error\n\texp: %q, %q\n\tgot: %q, %q\n", []string{`a: 1`}, expErrs, out, errs)
+	}
+	ln, err = net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fatal := errors.New(`fatal`)
+
+	if err := (&Server{Handler: h}).Serve(&failListener{ln, []error{fatal}}); err != fatal {
+		t.Errorf("\n\tfor: Serve after a fatal error\n\texp: %v\n\tgot: %v\n", fatal, err)
+	}
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("\n\tfor: listener after a fatal error\n\texp: %v\n\tgot: %v\n", net.ErrClosed, err)
+	}
+}
+
+func Test_ServerShutdownTimeout(t *testing.T) {
+	h := newCollectHandler()
+	s := &Server{Handler: h}
+	addr, done := startServer(t, s)
+
+	a := dial(t, addr, "<13>Oct 11 22:14:15 a: 1\n")
+	defer a.Close()
+
+	h.wait(t, 1)
+	a.Write([]byte(`<13>Oct 11 22:14:15 a: 2`))
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("\n\tfor: Shutdown\n\texp: %v\n\tgot: %v\n", context.DeadlineExceeded, err)
+	}
+	<-done
+	closed(t, a)
+
+	if out, errs := h.sorted(); len(out) != 1 || len(errs) > 0 {
+		t.Errorf("\n\tfor: Shutdown timed out\n\texp: %q\n\tgot: %q, %q\n", []string{`a: 1`}, out, errs)
+	}
+}
+
+func Test_ServerLimits(t *testing.T) {
+	h := newCollectHandler()
+	s := &Server{Handler: h, MaxConns: 1, IdleTimeout: 100 * time.Millisecond}
+	addr, done := startServer(t, s)
+	defer func() {
+		s.Close()
+		<-done
+	}()
+	a := dial(t, addr, "<13>Oct 11 22:14:15 a: 1\n")
+	defer a.Close()
+
+	h.wait(t, 1)
+	b := dial(t, addr)
+	defer b.Close()
+
+	closed(t, b)
+	h.wait(t, 1)
+	closed(t, a)
+	h.wait(t, 1)
+
+	exp := []string{`a: 1`}
+	expErrs := []string{`: connection limit reached`, `: idle timeout`}
+
+	if out, errs := h.sorted(); !reflect.DeepEqual(exp, out) || !reflect.DeepEqual(expErrs, errs) {
+		t.Errorf("\n\tfor: Server limits\n\texp: %q, %q\n\tgot: %q, %q\n", exp, expErrs, out, errs)
+	}
+}
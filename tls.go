@@ -0,0 +1,256 @@
+package syslogp
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// PeerAuth authorizes the peer of a TLS connection by its certificate,
+// as RFC 5425 (5.2) describes: a certificate is authorized if its fingerprint
+// is one of Fingerprints, whatever its issuer, even if self-signed, or if
+// it is valid against Roots and one of its names is one of Subjects.
+// Without Subjects and Fingerprints, any certificate valid against Roots
+// is authorized.
+type PeerAuth struct {
+	// Fingerprints are the fingerprints of the certificates authorized,
+	// in the form of RFC 5425 (4.2.2), such as "sha-256:E4:7C:...",
+	// sha-1, sha-224, sha-256, sha-384 and sha-512 being supported.
+	Fingerprints []string
+
+	// Subjects are the names authorized, matched against the dNSName
+	// entries of subjectAltName of a certificate or, without them, against
+	// the common name of its subject. A name of a certificate may begin
+	// with a "*" label, which matches any single label.
+	Subjects []string
+
+	// Roots are the trust anchors, the system ones if nil.
+	Roots *x509.CertPool
+}
+
+// Authorize reports whether the certificate chain of the peer, its own
+// certificate first, is authorized to be used for usage.
+func (a *PeerAuth) Authorize(certs []*x509.Certificate, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return ErrPeerUnauthorized
+	}
+	for _, fp := range a.Fingerprints {
+		if matchFingerprint(certs[0], fp) {
+			return nil
+		}
+	}
+	if len(a.Fingerprints) > 0 && len(a.Subjects) == 0 {
+		return ErrPeerUnauthorized
+	}
+	opts := x509.VerifyOptions{
+		Roots:         a.Roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return ErrPeerUnauthorized
+	}
+	if len(a.Subjects) == 0 {
+		return nil
+	}
+	names := certs[0].DNSNames
+
+	if len(names) == 0 && certs[0].Subject.CommonName != `` {
+		names = []string{certs[0].Subject.CommonName}
+	}
+	for _, s := range a.Subjects {
+		for _, n := range names {
+			if matchName(n, s) {
+				return nil
+			}
+		}
+	}
+	return ErrPeerUnauthorized
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert in the form of
+// RFC 5425 (4.2.2).
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	b := make([]byte, 0, 8+3*len(sum))
+	b = append(b, `sha-256`...)
+
+	for _, c := range sum {
+		b = append(b, ':', hexDigits[c>>4], hexDigits[c&15])
+	}
+	return string(b)
+}
+
+const hexDigits = `0123456789ABCDEF`
+
+func matchFingerprint(cert *x509.Certificate, fp string) bool {
+	i := strings.IndexByte(fp, ':')
+	if i < 0 {
+		return false
+	}
+	var h crypto.Hash
+
+	switch strings.ToLower(fp[:i]) {
+	case `sha-1`:
+		h = crypto.SHA1
+	case `sha-224`:
+		h = crypto.SHA224
+	case `sha-256`:
+		h = crypto.SHA256
+	case `sha-384`:
+		h = crypto.SHA384
+	case `sha-512`:
+		h = crypto.SHA512
+	default:
+		return false
+	}
+	sum, err := hex.DecodeString(strings.ReplaceAll(fp[i+1:], `:`, ``))
+	if err != nil || len(sum) != h.Size() {
+		return false
+	}
+	d := h.New()
+	d.Write(cert.Raw)
+	return string(d.Sum(nil)) == string(sum)
+}
+
+// matchName reports whether name matches pattern, a name of a certificate
+// that may begin with a "*" label.
+func matchName(pattern, name string) bool {
+	pattern = strings.TrimSuffix(pattern, `.`)
+	name = strings.TrimSuffix(name, `.`)
+
+	if strings.HasPrefix(pattern, `*.`) {
+		i := strings.IndexByte(name, '.')
+		if i < 1 {
+			return false
+		}
+		pattern, name = pattern[2:], name[i+1:]
+	}
+	return pattern != `` && strings.EqualFold(pattern, name)
+}
+
+// ListenTLS listens on the TCP address for TLS connections (RFC 5425),
+// to be served by Server. With auth, clients must present a certificate
+// it authorizes: config.ClientAuth, if NoClientCert, is raised to
+// RequireAnyClientCert, and config.VerifyConnection, if any, runs before
+// auth does, either rejecting the connection. Without auth, config applies
+// as is. TLS 1.2 is the least version accepted, unless config has another.
+// Sessions are resumed with session tickets, unless config disables them.
+// config is not modified.
+func ListenTLS(addr string, config *tls.Config, auth *PeerAuth) (net.Listener, error) {
+	if config = config.Clone(); config == nil {
+		config = &tls.Config{}
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if auth != nil {
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAnyClientCert
+		}
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			return auth.Authorize(cs.PeerCertificates, x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return tls.Listen(`tcp`, addr, config)
+}
+
+// TLSDialer dials TLS connections (RFC 5425) to a syslog server, to be
+// written frames by FrameWriter.
+type TLSDialer struct {
+	// Config is the TLS configuration, used as a template. Without
+	// ClientSessionCache, a cache of the dialer is used, so that sessions
+	// are resumed.
+	Config *tls.Config
+
+	// Auth, if not nil, authorizes the server, in place of the verification
+	// of its certificate against Config.RootCAs and Config.ServerName,
+	// after Config.VerifyConnection, if any.
+	Auth *PeerAuth
+
+	// Dialer dials the TCP connections.
+	Dialer net.Dialer
+
+	once   sync.Once
+	config *tls.Config
+}
+
+// Dial connects to the address and completes the TLS handshake.
+// The connection is then read in the background until closed, which
+// picks up the session tickets sent by TLS 1.3 servers after the handshake
+// and closes the connection when the server does, so Read must not be used.
+// A connection closed right after the handshake may not have got a ticket.
+func (d *TLSDialer) Dial(ctx context.Context, addr string) (*tls.Conn, error) {
+	d.once.Do(d.init)
+
+	config := d.config
+
+	if config.ServerName == `` {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	raw, err := d.Dialer.DialContext(ctx, `tcp`, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := tls.Client(raw, config)
+
+	if err = c.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	go func() {
+		io.Copy(io.Discard, c)
+		c.Close()
+	}()
+	return c, nil
+}
+
+func (d *TLSDialer) init() {
+	if d.config = d.Config.Clone(); d.config == nil {
+		d.config = &tls.Config{}
+	}
+	if d.config.MinVersion == 0 {
+		d.config.MinVersion = tls.VersionTLS12
+	}
+	if d.config.ClientSessionCache == nil {
+		d.config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if auth := d.Auth; auth != nil {
+		verify := d.config.VerifyConnection
+		d.config.InsecureSkipVerify = true
+		d.config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			return auth.Authorize(cs.PeerCertificates, x509.ExtKeyUsageServerAuth)
+		}
+	}
+}
+
+var ErrPeerUnauthorized = errors.New(`peer not authorized`)
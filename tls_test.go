@@ -0,0 +1,280 @@
+package syslogp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	tls  tls.Certificate
+}
+
+// newTestCert issues a certificate for name, with the DNS names given,
+// signed by parent, or self-signed if parent is nil.
+func newTestCert(t *testing.T, parent *testCert, name string, ca bool, dns ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dns,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ca {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	issuer, signer := tmpl, any(key)
+
+	if parent != nil {
+		issuer, signer = parent.cert, parent.tls.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}
+}
+
+func Test_PeerAuth(t *testing.T) {
+	ca := newTestCert(t, nil, `ca`, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	host := newTestCert(t, ca, `ignored`, false, `syslog.example.net`)
+	cn := newTestCert(t, ca, `relay.example.net`, false)
+	self := newTestCert(t, nil, `syslog.example.net`, false, `syslog.example.net`)
+	wild := newTestCert(t, ca, `wild`, false, `*.example.net`)
+
+	fp := Fingerprint(self.cert)
+	sum := sha1.Sum(self.cert.Raw)
+	fp1 := `sha-1`
+
+	for _, c := range sum {
+		fp1 += fmt.Sprintf(`:%02X`, c)
+	}
+	if s := strings.Split(fp, `:`); len(s) != 33 || s[0] != `sha-256` {
+		t.Errorf("\n\tfor: Fingerprint\n\texp: sha-256 and 32 bytes\n\tgot: %s\n", fp)
+	}
+	cases := [...]struct {
+		name string
+		auth PeerAuth
+		cert *testCert
+		err  error
+	}{
+		{`subject`, PeerAuth{Subjects: []string{`syslog.example.net`}, Roots: roots}, host, nil},
+		{`subject, case`, PeerAuth{Subjects: []string{`Syslog.Example.NET.`}, Roots: roots}, host, nil},
+		{`subject mismatch`, PeerAuth{Subjects: []string{`other.example.net`}, Roots: roots}, host, ErrPeerUnauthorized},
+		{`subject, SAN over CN`, PeerAuth{Subjects: []string{`ignored`}, Roots: roots}, host, ErrPeerUnauthorized},
+		{`subject, CN`, PeerAuth{Subjects: []string{`relay.example.net`}, Roots: roots}, cn, nil},
+		{`subject, wildcard`, PeerAuth{Subjects: []string{`a.example.net`}, Roots: roots}, wild, nil},
+		{`subject, wildcard, two labels`, PeerAuth{Subjects: []string{`a.b.example.net`}, Roots: roots}, wild, ErrPeerUnauthorized},
+		{`subject, untrusted`, PeerAuth{Subjects: []string{`syslog.example.net`}, Roots: roots}, self, ErrPeerUnauthorized},
+		{`any trusted`, PeerAuth{Roots: roots}, cn, nil},
+		{`any trusted, untrusted`, PeerAuth{Roots: roots}, self, ErrPeerUnauthorized},
+		{`fingerprint`, PeerAuth{Fingerprints: []string{fp}}, self, nil},
+		{`fingerprint, lower case`, PeerAuth{Fingerprints: []string{strings.ToLower(fp)}}, self, nil},
+		{`fingerprint mismatch`, PeerAuth{Fingerprints: []string{fp}}, host, ErrPeerUnauthorized},
+		{`fingerprint, sha-1`, PeerAuth{Fingerprints: []string{fp1}}, self, nil},
+		{`fingerprint, sha-1 mismatch`, PeerAuth{Fingerprints: []string{`sha-1` + strings.Repeat(`:00`, 20)}}, self, ErrPeerUnauthorized},
+		{`fingerprint, bad`, PeerAuth{Fingerprints: []string{`md5:00`, `sha-256`, `sha-256:zz`}}, self, ErrPeerUnauthorized},
+		{`fingerprint or subject`, PeerAuth{Fingerprints: []string{fp}, Subjects: []string{`syslog.example.net`}, Roots: roots}, host, nil},
+	}
+	for _, c := range cases {
+		if err := c.auth.Authorize([]*x509.Certificate{c.cert.cert}, x509.ExtKeyUsageServerAuth); err != c.err {
+			t.Errorf("\n\tfor: %s\n\texp: %v\n\tgot: %v\n", c.name, c.err, err)
+		}
+	}
+	if err := (&PeerAuth{}).Authorize(nil, x509.ExtKeyUsageServerAuth); err != ErrPeerUnauthorized {
+		t.Errorf("\n\tfor: no certificate\n\texp: %v\n\tgot: %v\n", ErrPeerUnauthorized, err)
+	}
+}
+
+func Test_TLS(t *testing.T) {
+	ca := newTestCert(t, nil, `ca`, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	server := newTestCert(t, ca, `server`, false, `syslog.example.net`)
+	client := newTestCert(t, ca, `client`, false, `client.example.net`)
+	self := newTestCert(t, nil, `relay`, false, `relay.example.net`)
+	stranger := newTestCert(t, nil, `stranger`, false, `client.example.net`)
+
+	l, err := ListenTLS(`127.0.0.1:0`, &tls.Config{Certificates: []tls.Certificate{server.tls}}, &PeerAuth{
+		Fingerprints: []string{Fingerprint(self.cert)},
+		Subjects:     []string{`client.example.net`},
+		Roots:        roots,
+	})
+	if err != nil {
+		t.Skip(err)
+	}
+	h := newCollectHandler()
+	s := &Server{Handler: h}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	auth := &PeerAuth{Subjects: []string{`syslog.example.net`}, Roots: roots}
+	send := func(d *TLSDialer, msg string) *tls.Conn {
+		c, err := d.Dial(ctx, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewFrameWriter(c, make([]byte, 64))
+
+		if _, err = w.Write([]byte(msg)); err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	d := &TLSDialer{Config: &tls.Config{Certificates: []tls.Certificate{client.tls}}, Auth: auth}
+
+	a := send(d, `<13>Oct 11 22:14:15 a: 1`)
+	h.wait(t, 1)
+
+	for i := 0; i < 500; i++ {
+		if _, ok := d.config.ClientSessionCache.Get(`127.0.0.1`); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Close()
+
+	b := send(d, `<13>Oct 11 22:14:15 b: 2`)
+	defer b.Close()
+	h.wait(t, 1)
+
+	if !b.ConnectionState().DidResume {
+		t.Errorf("\n\tfor: session resumption\n\texp: %v\n\tgot: %v\n", true, false)
+	}
+	c := send(&TLSDialer{Config: &tls.Config{Certificates: []tls.Certificate{self.tls}}, Auth: auth}, `<13>Oct 11 22:14:15 c: 3`)
+	defer c.Close()
+	h.wait(t, 1)
+
+	e := send(&TLSDialer{Config: &tls.Config{Certificates: []tls.Certificate{stranger.tls}}, Auth: auth}, `<13>Oct 11 22:14:15 e: 4`)
+	defer e.Close()
+	h.wait(t, 1)
+
+	strict := &TLSDialer{Config: &tls.Config{Certificates: []tls.Certificate{client.tls}}, Auth: &PeerAuth{Subjects: []string{`other.example.net`}, Roots: roots}}
+	if _, err := strict.Dial(ctx, l.Addr().String()); !errors.Is(err, ErrPeerUnauthorized) {
+		t.Errorf("\n\tfor: unauthorized server\n\texp: %v\n\tgot: %v\n", ErrPeerUnauthorized, err)
+	}
+	h.wait(t, 1)
+	verified := &TLSDialer{Config: &tls.Config{Certificates: []tls.Certificate{client.tls}, RootCAs: roots, ServerName: `syslog.example.net`}}
+	f := send(verified, `<13>Oct 11 22:14:15 f: 5`)
+	defer f.Close()
+	h.wait(t, 1)
+
+	s.Close()
+	<-done
+
+	exp := []string{`a: 1`, `b: 2`, `c: 3`, `f: 5`}
+
+	out, errs := h.sorted()
+	if !reflect.DeepEqual(exp, out) || len(errs) != 2 || !strings.HasSuffix(errs[0], ErrPeerUnauthorized.Error()) {
+		t.Errorf("\n\tfor: TLS\n\texp: %q, [%q, bad certificate]\n\tgot: %q, %q\n", exp, ErrPeerUnauthorized, out, errs)
+	}
+}
+
+func Test_ListenTLSConfig(t *testing.T) {
+	ca := newTestCert(t, nil, `ca`, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	server := newTestCert(t, ca, `server`, false, `syslog.example.net`)
+	client := newTestCert(t, ca, `client`, false, `client.example.net`)
+	vetoed := newTestCert(t, ca, `vetoed`, false, `vetoed.example.net`)
+	self := newTestCert(t, nil, `relay`, false, `relay.example.net`)
+
+	errVetoed := errors.New(`vetoed`)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{server.tls},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if cs.PeerCertificates[0].Subject.CommonName == `vetoed` {
+				return errVetoed
+			}
+			return nil
+		},
+	}
+	l, err := ListenTLS(`127.0.0.1:0`, config, &PeerAuth{
+		Fingerprints: []string{Fingerprint(self.cert)},
+		Subjects:     []string{`client.example.net`, `vetoed.example.net`},
+		Roots:        roots,
+	})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			errs <- c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	cases := [...]struct {
+		name string
+		cert *testCert
+		ok   bool
+		err  error
+	}{
+		{`authorized`, client, true, nil},
+		{`vetoed by VerifyConnection`, vetoed, false, errVetoed},
+		{`untrusted, ClientAuth kept`, self, false, nil},
+	}
+	for _, c := range cases {
+		cert := c.cert.tls
+		conn, _ := tls.Dial(`tcp`, l.Addr().String(), &tls.Config{
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &cert, nil
+			},
+			RootCAs:    roots,
+			ServerName: `syslog.example.net`,
+			MaxVersion: tls.VersionTLS12,
+		})
+		if conn != nil {
+			conn.Close()
+		}
+		if err := <-errs; (err == nil) != c.ok || (c.err != nil && err != c.err) {
+			t.Errorf("\n\tfor: %s\n\texp: %v, %v\n\tgot: %v\n", c.name, c.ok, c.err, err)
+		}
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("\n\tfor: config\n\texp: %v\n\tgot: %v\n", tls.RequireAndVerifyClientCert, config.ClientAuth)
+	}
+}
@@ -0,0 +1,192 @@
+package syslogp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPReceiver receives syslog messages over UDP (RFC 5426), a message
+// per datagram, and hands them over to Handler parsed by ParseMessage.
+type UDPReceiver struct {
+	// Handler handles the messages received.
+	Handler Handler
+
+	// Workers is the number of goroutines reading datagrams, 1 if zero.
+	Workers int
+
+	// ReadBuffer is the size of the socket receive buffer,
+	// the system default if zero.
+	ReadBuffer int
+
+	// MaxSize is the size of the largest datagram accepted,
+	// DefaultMaxDatagramSize if zero. Larger ones are dropped, reported
+	// to Handler, if it is an ErrorHandler, with ErrDatagramTruncated.
+	MaxSize int
+
+	// Batch is the number of datagrams read at once by a worker,
+	// with recvmmsg(2) on Linux, into Batch buffers of MaxSize.
+	// Elsewhere, or if it is less than 2, datagrams are read one by one.
+	Batch int
+
+	// Shards is the number of sockets ListenAndServe binds to the address
+	// with SO_REUSEPORT on Linux, each served by Workers, 1 if zero.
+	Shards int
+
+	mu     sync.Mutex
+	conns  map[net.PacketConn]struct{}
+	closed bool
+}
+
+// DefaultMaxDatagramSize is the largest UDP payload over IPv4.
+const DefaultMaxDatagramSize = 65507
+
+// ListenAndServe listens on the UDP address, with Shards sockets,
+// and serves them.
+func (r *UDPReceiver) ListenAndServe(addr string) error {
+	conns, err := ListenUDP(addr, r.Shards)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(conns))
+
+	for _, conn := range conns {
+		go func(conn net.PacketConn) { errs <- r.Serve(conn) }(conn)
+	}
+	for range conns {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}
+	}
+	return err
+}
+
+// Serve reads datagrams from conn until it is closed, when it returns nil,
+// or fails to read, when it closes conn and returns the error.
+func (r *UDPReceiver) Serve(conn net.PacketConn) error {
+	if !r.track(conn, true) {
+		conn.Close()
+		return ErrReceiverClosed
+	}
+	defer r.track(conn, false)
+
+	if b, ok := conn.(interface{ SetReadBuffer(int) error }); ok && r.ReadBuffer > 0 {
+		if err := b.SetReadBuffer(r.ReadBuffer); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	n := max(r.Workers, 1)
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		go func() { errs <- r.serve(conn) }()
+	}
+	var err error
+
+	for i := 0; i < n; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			conn.Close()
+		}
+	}
+	return err
+}
+
+func (r *UDPReceiver) serve(conn net.PacketConn) error {
+	if r.Batch > 1 {
+		if ok, err := r.serveBatch(conn); ok {
+			return err
+		}
+	}
+	var (
+		m    Message
+		size = r.maxSize()
+		buf  = make([]byte, size+1)
+	)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if n > size {
+			drop(r.Handler, addr, buf[:size])
+			continue
+		}
+		m.Addr, m.Received = addr, time.Now()
+		deliver(r.Handler, &m, buf[:n])
+	}
+}
+
+func (r *UDPReceiver) maxSize() int {
+	if r.MaxSize > 0 {
+		return r.MaxSize
+	}
+	return DefaultMaxDatagramSize
+}
+
+// Close closes the connections served, making Serve return.
+func (r *UDPReceiver) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+
+	for conn := range r.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (r *UDPReceiver) track(conn net.PacketConn, add bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !add {
+		delete(r.conns, conn)
+		return true
+	}
+	if r.closed {
+		return false
+	}
+	if r.conns == nil {
+		r.conns = make(map[net.PacketConn]struct{})
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+// deliver parses data into m, keeping what the receiver set, and hands it
+// over to h, or the error to h if it is an ErrorHandler.
+func deliver(h Handler, m *Message, data []byte) {
+	if err := ParseMessage(data, m); err != nil {
+		if e, ok := h.(ErrorHandler); ok {
+			e.HandleError(data, m.Addr, err)
+		}
+		return
+	}
+	h.HandleMessage(m)
+}
+
+// drop reports a datagram larger than MaxSize, cut to data, to h if it
+// is an ErrorHandler.
+func drop(h Handler, addr net.Addr, data []byte) {
+	if e, ok := h.(ErrorHandler); ok {
+		e.HandleError(data, addr, ErrDatagramTruncated)
+	}
+}
+
+var (
+	ErrReceiverClosed    = errors.New(`receiver closed`)
+	ErrDatagramTruncated = errors.New(`datagram truncated`)
+)
@@ -0,0 +1,159 @@
+//go:build linux
+
+package syslogp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ListenUDP listens on n UDP sockets bound to addr with SO_REUSEPORT,
+// so that the kernel spreads datagrams over them.
+func ListenUDP(addr string, n int) ([]net.PacketConn, error) {
+	var lc net.ListenConfig
+
+	if n > 1 {
+		lc.Control = func(network, address string, c syscall.RawConn) (err error) {
+			if e := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort(), 1)
+			}); e != nil {
+				return e
+			}
+			return
+		}
+	}
+	conns := make([]net.PacketConn, 0, max(n, 1))
+
+	for i := 0; i < max(n, 1); i++ {
+		conn, err := lc.ListenPacket(context.Background(), `udp`, addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		if conns = append(conns, conn); i == 0 {
+			addr = conn.LocalAddr().String()
+		}
+	}
+	return conns, nil
+}
+
+func soReusePort() int {
+	switch runtime.GOARCH {
+	case `mips`, `mipsle`, `mips64`, `mips64le`:
+		return 0x200
+	}
+	return 0xf
+}
+
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// serveBatch reads datagrams from conn with recvmmsg(2), Batch at once,
+// into buffers of its own reused for every batch. It reports false if
+// conn is not a socket.
+func (r *UDPReceiver) serveBatch(conn net.PacketConn) (bool, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false, nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+	var (
+		m     Message
+		n     = r.Batch
+		size  = r.maxSize()
+		buf   = make([]byte, n*size)
+		iov   = make([]syscall.Iovec, n)
+		names = make([]syscall.RawSockaddrAny, n)
+		msgs  = make([]mmsghdr, n)
+		addrs = make([]net.UDPAddr, n)
+		ips   = make([][16]byte, n)
+	)
+	for i := range msgs {
+		iov[i].Base = &buf[i*size]
+		iov[i].SetLen(size)
+		msgs[i].hdr.Iov = &iov[i]
+		msgs[i].hdr.Iovlen = 1
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+	}
+	for {
+		var (
+			k     int
+			errno syscall.Errno
+		)
+		for i := range msgs {
+			msgs[i].hdr.Namelen = uint32(unsafe.Sizeof(names[i]))
+			msgs[i].hdr.Flags = 0
+		}
+		err = rc.Read(func(fd uintptr) bool {
+			for {
+				r1, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&msgs[0])), uintptr(n), 0, 0, 0)
+
+				switch e {
+				case syscall.EINTR:
+					continue
+				case syscall.EAGAIN:
+					return false
+				}
+				k, errno = int(r1), e
+				return true
+			}
+		})
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return true, nil
+			}
+			return true, err
+		}
+		if errno != 0 {
+			return true, os.NewSyscallError(`recvmmsg`, errno)
+		}
+		now := time.Now()
+
+		for i := 0; i < k; i++ {
+			m.Addr, m.Received = sockaddr(&names[i], &addrs[i], &ips[i]), now
+
+			if msgs[i].hdr.Flags&syscall.MSG_TRUNC != 0 {
+				drop(r.Handler, m.Addr, buf[i*size:(i+1)*size])
+				continue
+			}
+			deliver(r.Handler, &m, buf[i*size:i*size+min(int(msgs[i].len), size)])
+		}
+	}
+}
+
+// sockaddr converts sa to a, whose IP is kept in ip, so as not to allocate.
+func sockaddr(sa *syscall.RawSockaddrAny, a *net.UDPAddr, ip *[16]byte) net.Addr {
+	switch sa.Addr.Family {
+	case syscall.AF_INET:
+		p := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		a.IP = ip[:copy(ip[:], p.Addr[:])]
+		a.Port = port(&p.Port)
+	case syscall.AF_INET6:
+		p := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		a.IP = ip[:copy(ip[:], p.Addr[:])]
+		a.Port = port(&p.Port)
+	default:
+		return nil
+	}
+	a.Zone = ``
+	return a
+}
+
+// port returns a port in network byte order.
+func port(p *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return int(b[0])<<8 | int(b[1])
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package syslogp
+
+import "net"
+
+// ListenUDP listens on the UDP address. Sharding with SO_REUSEPORT is
+// supported on Linux only, elsewhere there is just one socket whatever n is.
+func ListenUDP(addr string, n int) ([]net.PacketConn, error) {
+	conn, err := net.ListenPacket(`udp`, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.PacketConn{conn}, nil
+}
+
+// serveBatch reports false, as batch reads are supported on Linux only.
+func (r *UDPReceiver) serveBatch(conn net.PacketConn) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,167 @@
+package syslogp
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collectHandler struct {
+	mu     sync.Mutex
+	out    []string
+	errs   []string
+	addrs  []net.Addr
+	notify chan struct{}
+}
+
+func newCollectHandler() *collectHandler {
+	return &collectHandler{notify: make(chan struct{}, 1024)}
+}
+
+func (h *collectHandler) HandleMessage(m *Message) {
+	h.mu.Lock()
+	h.out = append(h.out, string(m.AppName)+`: `+string(m.Msg))
+	h.addrs = append(h.addrs, m.Addr)
+	h.mu.Unlock()
+	h.notify <- struct{}{}
+}
+
+func (h *collectHandler) HandleError(data []byte, addr net.Addr, err error) {
+	h.mu.Lock()
+	h.errs = append(h.errs, string(data)+`: `+err.Error())
+	h.mu.Unlock()
+	h.notify <- struct{}{}
+}
+
+func (h *collectHandler) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-h.notify:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d messages, got %d", n, i)
+		}
+	}
+}
+
+func (h *collectHandler) sorted() ([]string, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sort.Strings(h.out)
+	return h.out, h.errs
+}
+
+func Test_UDPReceiver(t *testing.T) {
+	conn, err := net.ListenPacket(`udp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Skip(err)
+	}
+	h := newCollectHandler()
+	r := &UDPReceiver{Handler: h, Workers: 2, ReadBuffer: 1 << 16, MaxSize: 64}
+	done := make(chan error)
+	go func() { done <- r.Serve(conn) }()
+
+	c, err := net.Dial(`udp`, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, p := range []string{
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 - first`,
+		"<13>Oct 11 22:14:15 sshd[1234]: second\n",
+		`<999>x`,
+		`<13>Oct 11 22:14:15 app: ` + strings.Repeat(`x`, 64),
+	} {
+		c.Write([]byte(p))
+	}
+	h.wait(t, 4)
+
+	if err := r.Close(); err != nil {
+		t.Errorf("\n\tfor: Close\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("\n\tfor: Serve\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	exp := []string{`sshd: second`, `su: first`}
+	expErrs := []string{`<13>Oct 11 22:14:15 app: ` + strings.Repeat(`x`, 39) + `: datagram truncated`, `<999>x: invalid priority`}
+
+	out, errs := h.sorted()
+	sort.Strings(errs)
+
+	if !reflect.DeepEqual(exp, out) || !reflect.DeepEqual(expErrs, errs) {
+		t.Errorf("\n\tfor: UDPReceiver\n\texp: %q, %q\n\tgot: %q, %q\n", exp, expErrs, out, errs)
+	}
+	if a := h.addrs[0].String(); a != c.LocalAddr().String() {
+		t.Errorf("\n\tfor: source address\n\texp: %s\n\tgot: %s\n", c.LocalAddr(), a)
+	}
+	if err := r.Serve(conn); err != ErrReceiverClosed {
+		t.Errorf("\n\tfor: Serve after Close\n\texp: %v\n\tgot: %v\n", ErrReceiverClosed, err)
+	}
+}
+
+func Test_UDPReceiverBatch(t *testing.T) {
+	const frames = 64
+
+	conns, err := ListenUDP(`127.0.0.1:0`, 2)
+	if err != nil {
+		t.Skip(err)
+	}
+	if runtime.GOOS == `linux` && len(conns) != 2 {
+		t.Errorf("\n\tfor: ListenUDP\n\texp: 2 sockets\n\tgot: %d\n", len(conns))
+	}
+	h := newCollectHandler()
+	r := &UDPReceiver{Handler: h, Batch: 8, MaxSize: 512}
+	done := make(chan error, len(conns))
+
+	for _, conn := range conns {
+		go func(conn net.PacketConn) { done <- r.Serve(conn) }(conn)
+	}
+	exp := []string{}
+
+	for i := 0; i < frames; i++ {
+		c, err := net.Dial(`udp`, conns[0].LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := fmt.Sprintf(`<13>Oct 11 22:14:15 app[%d]: %03d`, i, i)
+		exp = append(exp, fmt.Sprintf(`app: %03d`, i))
+
+		if _, err = c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	c, err := net.Dial(`udp`, conns[0].LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte(strings.Repeat(`x`, 600)))
+	c.Close()
+
+	h.wait(t, frames+1)
+	r.Close()
+
+	for range conns {
+		if err := <-done; err != nil {
+			t.Errorf("\n\tfor: Serve\n\texp: %v\n\tgot: %v\n", nil, err)
+		}
+	}
+	expErrs := []string{strings.Repeat(`x`, 512) + `: ` + ErrDatagramTruncated.Error()}
+
+	if out, errs := h.sorted(); !reflect.DeepEqual(exp, out) || !reflect.DeepEqual(expErrs, errs) {
+		t.Errorf("\n\tfor: batch of %d\n\texp: %q, %q\n\tgot: %q, %q\n", frames, exp, expErrs, out, errs)
+	}
+	for _, a := range h.addrs {
+		if u, ok := a.(*net.UDPAddr); !ok || !u.IP.Equal(net.IPv4(127, 0, 0, 1)) || u.Port == 0 {
+			t.Errorf("\n\tfor: source address\n\texp: 127.0.0.1:*\n\tgot: %v\n", a)
+			break
+		}
+	}
+}
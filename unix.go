@@ -0,0 +1,267 @@
+package syslogp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UnixReceiver receives syslog messages from local processes over unix domain
+// sockets, the way syslog(3) of glibc sends them to /dev/log: a message per
+// datagram, or, over a stream socket, frames terminated by NUL. Messages
+// are parsed by ParseMessage, those without HOSTNAME, as syslog(3) sends
+// them, get the one of the local host, and on Linux the credentials of
+// the sender, which the kernel vouches for, are attached as Cred.
+type UnixReceiver struct {
+	// Handler handles the messages received.
+	Handler Handler
+
+	// Hostname is HOSTNAME of the messages without one, os.Hostname()
+	// if empty.
+	Hostname string
+
+	// Workers is the number of goroutines reading datagrams, 1 if zero.
+	Workers int
+
+	// ReadBuffer is the size of the socket receive buffer,
+	// the system default if zero.
+	ReadBuffer int
+
+	// MaxSize is the size of the largest message accepted,
+	// DefaultMaxDatagramSize if zero. Larger datagrams are dropped, reported
+	// to Handler, if it is an ErrorHandler, with ErrDatagramTruncated,
+	// larger frames are skipped.
+	MaxSize int
+
+	once   sync.Once
+	host   []byte
+	mu     sync.Mutex
+	conns  map[*net.UnixConn]struct{}
+	stream Server
+	closed bool
+}
+
+// Cred are the credentials of the process that sent a message over a unix
+// domain socket.
+type Cred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// ListenAndServe listens on the unixgram socket at path and serves it.
+func (r *UnixReceiver) ListenAndServe(path string) error {
+	conn, err := ListenUnixgram(path)
+	if err != nil {
+		return err
+	}
+	return r.Serve(conn)
+}
+
+// Serve reads datagrams from conn until it is closed, when it returns nil,
+// or fails to read, when it closes conn and returns the error. To get the
+// credentials of the senders, conn must be made by ListenUnixgram.
+func (r *UnixReceiver) Serve(conn *net.UnixConn) error {
+	if !r.track(conn, true) {
+		conn.Close()
+		return ErrReceiverClosed
+	}
+	defer r.track(conn, false)
+
+	if r.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(r.ReadBuffer); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	r.once.Do(r.init)
+
+	n := max(r.Workers, 1)
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		go func() { errs <- r.serve(conn) }()
+	}
+	var err error
+
+	for i := 0; i < n; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			conn.Close()
+		}
+	}
+	return err
+}
+
+func (r *UnixReceiver) serve(conn *net.UnixConn) error {
+	var (
+		m    Message
+		cred Cred
+		size = r.maxSize()
+		buf  = make([]byte, size+1)
+		oob  = make([]byte, credSpace)
+		h    = localHandler{r.Handler, r.host}
+	)
+	for {
+		n, addr, ok, err := readCred(conn, buf, oob, &cred)
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if m.Addr, m.Cred, m.Received = nil, nil, time.Now(); addr != nil {
+			m.Addr = addr
+		}
+		if n > size {
+			drop(h, m.Addr, buf[:size])
+			continue
+		}
+		if ok {
+			m.Cred = &cred
+		}
+		deliver(h, &m, buf[:n])
+	}
+}
+
+// ServeStream serves the connections l accepts, made by ListenUnix, reading
+// frames terminated by NUL, or octet-counted ones, until l is closed, when
+// it returns nil, or fails to accept, when it closes l and returns the error.
+func (r *UnixReceiver) ServeStream(l net.Listener) error {
+	r.once.Do(r.init)
+	return r.stream.Serve(l)
+}
+
+func (r *UnixReceiver) init() {
+	if r.host = []byte(r.Hostname); len(r.host) == 0 {
+		if host, err := os.Hostname(); err == nil && host != `` {
+			r.host = []byte(host)
+		}
+	}
+	r.stream.Handler = localHandler{r.Handler, r.host}
+	r.stream.Trailer = TrailerNUL
+	r.stream.MaxFrameSize = r.maxSize()
+}
+
+func (r *UnixReceiver) maxSize() int {
+	if r.MaxSize > 0 {
+		return r.MaxSize
+	}
+	return DefaultMaxDatagramSize
+}
+
+// Close closes the sockets and the connections served, making Serve
+// and ServeStream return.
+func (r *UnixReceiver) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+
+	for conn := range r.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := r.stream.Close(); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+func (r *UnixReceiver) track(conn *net.UnixConn, add bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !add {
+		delete(r.conns, conn)
+		return true
+	}
+	if r.closed {
+		return false
+	}
+	if r.conns == nil {
+		r.conns = make(map[*net.UnixConn]struct{})
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+// localHandler fills in HOSTNAME of the messages without one.
+type localHandler struct {
+	h    Handler
+	host []byte
+}
+
+func (h localHandler) HandleMessage(m *Message) {
+	if m.Hostname == nil {
+		m.Hostname = h.host
+	}
+	h.h.HandleMessage(m)
+}
+
+func (h localHandler) HandleError(data []byte, addr net.Addr, err error) {
+	if e, ok := h.h.(ErrorHandler); ok {
+		e.HandleError(data, addr, err)
+	}
+}
+
+// ListenUnixgram listens on the unixgram socket at path, replacing a stale
+// socket left there, one that refuses connections, and lets anyone write
+// to it, as to /dev/log. A socket still served is left in place, and
+// ListenUnixgram fails. On Linux, it asks the kernel to pass
+// the credentials of the senders.
+func ListenUnixgram(path string) (*net.UnixConn, error) {
+	if err := removeSocket(`unixgram`, path); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram(`unixgram`, &net.UnixAddr{Name: path, Net: `unixgram`})
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0666); err == nil {
+		err = passCred(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenUnix listens on the unix stream socket at path, replacing a stale
+// socket left there, as ListenUnixgram does, and lets anyone connect to it.
+func ListenUnix(path string) (*net.UnixListener, error) {
+	if err := removeSocket(`unix`, path); err != nil {
+		return nil, err
+	}
+	l, err := net.ListenUnix(`unix`, &net.UnixAddr{Name: path, Net: `unix`})
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0666); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeSocket removes the socket at path if nothing is bound to it,
+// which the refusal of a connection to it tells.
+func removeSocket(network, path string) error {
+	if fi, err := os.Lstat(path); err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	c, err := net.Dial(network, path)
+	if err == nil {
+		return c.Close()
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return os.Remove(path)
+	}
+	return nil
+}
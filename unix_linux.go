@@ -0,0 +1,70 @@
+//go:build linux
+
+package syslogp
+
+import (
+	"net"
+	"syscall"
+)
+
+// credSpace is the size of the ancillary data with the credentials.
+var credSpace = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// passCred sets SO_PASSCRED on conn, so that the credentials of the sender
+// come with every datagram.
+func passCred(conn *net.UnixConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if e := rc.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// readCred reads a datagram into buf and reports whether the credentials
+// of its sender have been read into cred.
+func readCred(conn *net.UnixConn, buf, oob []byte, cred *Cred) (n int, addr *net.UnixAddr, ok bool, err error) {
+	var oobn int
+
+	if n, oobn, _, addr, err = conn.ReadMsgUnix(buf, oob); err != nil || oobn == 0 {
+		return
+	}
+	msgs, e := syscall.ParseSocketControlMessage(oob[:oobn])
+	if e != nil {
+		return
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET || msgs[i].Header.Type != syscall.SCM_CREDENTIALS {
+			continue
+		}
+		if u, e := syscall.ParseUnixCredentials(&msgs[i]); e == nil {
+			cred.Pid, cred.Uid, cred.Gid, ok = u.Pid, u.Uid, u.Gid, true
+		}
+	}
+	return
+}
+
+// peerCred returns the credentials of the peer of a unix stream connection,
+// got with SO_PEERCRED, or nil.
+func peerCred(c net.Conn) *Cred {
+	u, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	rc, err := u.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+
+	if e := rc.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); e != nil || err != nil {
+		return nil
+	}
+	return &Cred{Pid: cred.Pid, Uid: cred.Uid, Gid: cred.Gid}
+}
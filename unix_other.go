@@ -0,0 +1,24 @@
+//go:build !linux
+
+package syslogp
+
+import "net"
+
+// credSpace is zero, as credentials are passed on Linux only.
+const credSpace = 0
+
+// passCred does nothing, as credentials are passed on Linux only.
+func passCred(conn *net.UnixConn) error {
+	return nil
+}
+
+// readCred reads a datagram into buf, without the credentials of its sender.
+func readCred(conn *net.UnixConn, buf, oob []byte, cred *Cred) (n int, addr *net.UnixAddr, ok bool, err error) {
+	n, addr, err = conn.ReadFromUnix(buf)
+	return
+}
+
+// peerCred returns nil, as credentials are got on Linux only.
+func peerCred(c net.Conn) *Cred {
+	return nil
+}
@@ -0,0 +1,155 @@
+package syslogp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type localCollector struct {
+	out chan string
+}
+
+func (h localCollector) HandleMessage(m *Message) {
+	cred := `-`
+
+	if m.Cred != nil {
+		cred = fmt.Sprintf(`%d:%d:%d`, m.Cred.Pid, m.Cred.Uid, m.Cred.Gid)
+	}
+	h.out <- fmt.Sprintf(`%s %s[%s] %s %s`, m.Hostname, m.AppName, m.ProcId, m.Msg, cred)
+}
+
+func (h localCollector) HandleError(data []byte, addr net.Addr, err error) {
+	h.out <- fmt.Sprintf(`%d bytes: %v`, len(data), err)
+}
+
+func (h localCollector) collect(t *testing.T, n int) []string {
+	out := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		select {
+		case s := <-h.out:
+			out = append(out, s)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d messages, got %d", n, i)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func localCred() string {
+	if runtime.GOOS != `linux` {
+		return `-`
+	}
+	return fmt.Sprintf(`%d:%d:%d`, os.Getpid(), os.Getuid(), os.Getgid())
+}
+
+func Test_UnixReceiver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `log`)
+
+	conn, err := ListenUnixgram(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	h := localCollector{make(chan string, 16)}
+	r := &UnixReceiver{Handler: h, Hostname: `local`, Workers: 2, MaxSize: 512}
+	done := make(chan error)
+	go func() { done <- r.Serve(conn) }()
+
+	c, err := net.Dial(`unixgram`, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, p := range []string{
+		`<13>Oct 11 22:14:15 sshd[1234]: first`,
+		"<13>Oct 11 22:14:15 cron: second\x00",
+		`<34>1 2003-10-11T22:14:15.003Z host su 7 - - third`,
+		`<13>Oct 11 22:14:15 app: ` + strings.Repeat(`x`, 600),
+	} {
+		if _, err = c.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cred := localCred()
+	exp := []string{
+		`512 bytes: ` + ErrDatagramTruncated.Error(),
+		`host su[7] third ` + cred,
+		`local cron[] second ` + cred,
+		`local sshd[1234] first ` + cred,
+	}
+	if out := h.collect(t, 4); !reflect.DeepEqual(exp, out) {
+		t.Errorf("\n\tfor: UnixReceiver\n\texp: %q\n\tgot: %q\n", exp, out)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0666 {
+		t.Errorf("\n\tfor: socket mode\n\texp: %v\n\tgot: %v, %v\n", os.FileMode(0666), fi.Mode().Perm(), err)
+	}
+	if live, err := ListenUnixgram(path); err == nil {
+		live.Close()
+		t.Errorf("\n\tfor: ListenUnixgram over a live socket\n\texp: an error\n\tgot: %v\n", err)
+	}
+	c.Write([]byte(`<13>Oct 11 22:14:15 app: still served`))
+
+	if out := h.collect(t, 1); !reflect.DeepEqual([]string{`local app[] still served ` + cred}, out) {
+		t.Errorf("\n\tfor: live socket kept\n\texp: %q\n\tgot: %q\n", `local app[] still served `+cred, out)
+	}
+	r.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("\n\tfor: Serve\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+	conn, err = ListenUnixgram(path)
+	if err != nil {
+		t.Errorf("\n\tfor: ListenUnixgram over a stale socket\n\texp: %v\n\tgot: %v\n", nil, err)
+	} else {
+		conn.Close()
+	}
+}
+
+func Test_UnixReceiverStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `log`)
+
+	l, err := ListenUnix(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	h := localCollector{make(chan string, 16)}
+	r := &UnixReceiver{Handler: h}
+	done := make(chan error)
+	go func() { done <- r.ServeStream(l) }()
+
+	c, err := net.Dial(`unix`, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err = c.Write([]byte("<13>Oct 11 22:14:15 app[1]: a\x00<13>Oct 11 22:14:15 app[1]: b\n\x00")); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	cred := localCred()
+	exp := []string{host + ` app[1] a ` + cred, host + ` app[1] b ` + cred}
+
+	if out := h.collect(t, 2); !reflect.DeepEqual(exp, out) {
+		t.Errorf("\n\tfor: UnixReceiver stream\n\texp: %q\n\tgot: %q\n", exp, out)
+	}
+	if live, err := ListenUnix(path); err == nil {
+		live.Close()
+		t.Errorf("\n\tfor: ListenUnix over a live socket\n\texp: an error\n\tgot: %v\n", err)
+	}
+	r.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("\n\tfor: ServeStream\n\texp: %v\n\tgot: %v\n", nil, err)
+	}
+}